@@ -1,20 +1,63 @@
 {{range .configList}}
 - type: container
   enabled: true
+  {{- if $.inputId }}
+  id: {{ $.inputId }}-{{ .Name }}
+  {{- end }}
   paths:
       - {{ .HostDir }}/{{ .File }}
+  {{- if .Stream }}
+  stream: {{ .Stream }}
+  {{- end }}
   exclude_files: ['\.gz$']
   scan_frequency: 10s
   harvester_limit: 1024
   fields_under_root: true
   fields:
       {{range $key, $value := .Tags}}
-      {{ $key }}: {{ $value }}
+      {{ $key }}: {{ quote $value }}
       {{end}}
       {{range $key, $value := $.container}}
-      {{ $key }}: {{ $value }}
+      {{ $key }}: {{ quote $value }}
       {{end}}
-  tail_files: false
+  {{- if .Multiline }}
+  multiline.type: pattern
+  multiline.pattern: {{ quote .Multiline.Pattern }}
+  multiline.negate: {{ .Multiline.Negate }}
+  multiline.match: {{ .Multiline.Match }}
+  {{- end }}
+  {{- if .IncludeLines }}
+  include_lines:
+      {{- range .IncludeLines }}
+      - {{ quote . }}
+      {{- end }}
+  {{- end }}
+  {{- if .ExcludeLines }}
+  exclude_lines:
+      {{- range .ExcludeLines }}
+      - {{ quote . }}
+      {{- end }}
+  {{- end }}
+  {{- if .IgnoreOlder }}
+  ignore_older: {{ .IgnoreOlder }}
+  {{- end }}
+  {{- if .Encoding }}
+  encoding: {{ .Encoding }}
+  {{- end }}
+  {{- if or .Sample .Mask }}
+  processors:
+  {{- if .Sample }}
+      - script:
+          lang: javascript
+          source: {{ quote (sampleScript .Sample.Rates .Sample.Fields .Sample.Pattern) }}
+  {{- end }}
+  {{- if .Mask }}
+      - script:
+          lang: javascript
+          source: {{ quote (maskScript .Mask) }}
+  {{- end }}
+  {{- end }}
+  tail_files: {{ .TailFiles }}
   close_inactive: 2h
   close_eof: false
   close_removed: true
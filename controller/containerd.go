@@ -11,10 +11,11 @@ import (
 	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/oci"
 	"github.com/zeromicro/go-zero/core/logc"
-	"io"
 	"regexp"
 	"strings"
+	"time"
 	"watchlog/pkg/ctx"
+	"watchlog/pkg/metrics"
 	"watchlog/pkg/runtime"
 )
 
@@ -37,6 +38,7 @@ func (c Containerd) ProcessContainers() error {
 	c.ctx.Lock()
 	defer c.ctx.Unlock()
 
+	since := time.Now()
 	containerCtx := namespaces.WithNamespace(c.ctx.Context, "k8s.io")
 	c.watchEvent(c.ctx, containerCtx)
 
@@ -46,15 +48,29 @@ func (c Containerd) ProcessContainers() error {
 		return err
 	}
 
+	running := make(map[string]bool)
 	for _, container := range containers {
+		running[container.ID()] = true
 		if err := c.processContainer(containerCtx, container); err != nil {
 			logc.Errorf(context.Background(), "process container failed: %v", err)
 		}
 	}
+	PruneConfigs(c.ctx, running, since)
+	c.ctx.SetSynced()
 
 	return nil
 }
 
+// Resync 重新读取容器信息并生成采集配置
+func (c Containerd) Resync(id string) error {
+	containerCtx := namespaces.WithNamespace(c.ctx.Context, "k8s.io")
+	container, err := c.ctx.ContainerdCli.LoadContainer(containerCtx, id)
+	if err != nil {
+		return fmt.Errorf("load container %s failed: %s", id, err.Error())
+	}
+	return c.processContainer(containerCtx, container)
+}
+
 func (c Containerd) processContainer(containerCtx context.Context, container containerd.Container) error {
 	meta, err := container.Info(containerCtx)
 	if err != nil {
@@ -73,20 +89,37 @@ func (c Containerd) watchEvent(ctx *ctx.Context, containerCtx context.Context) {
 	msgs, errs := c.ctx.ContainerdCli.EventService().Subscribe(containerCtx, "")
 
 	go func() {
-		defer logc.Info(context.Background(), "finish to watch containerd event")
 		logc.Infof(context.Background(), "begin to watch containerd event")
 
+		backoff := time.Second
+		var recovered <-chan time.Time
 		for {
 			select {
-			case msg := <-msgs:
+			case msg, ok := <-msgs:
+				if !ok {
+					msgs = nil
+					continue
+				}
+				backoff = time.Second
+				ctx.StreamUp()
 				if err := c.processEvent(ctx, containerCtx, msg); err != nil {
 					logc.Errorf(context.Background(), "process event failed: %v", err)
 				}
+			case <-recovered:
+				recovered = nil
+				backoff = time.Second
+				ctx.StreamUp()
 			case err := <-errs:
-				if err == io.EOF || err == io.ErrUnexpectedEOF {
-					return
+				// 订阅断开后重新订阅
+				ctx.StreamDown()
+				logc.Errorf(context.Background(), "event subscription error: %v, resubscribe in %s", err, backoff)
+				time.Sleep(backoff)
+				if backoff < 30*time.Second {
+					backoff *= 2
 				}
-				logc.Errorf(context.Background(), "event subscription error: %v", err)
+				metrics.EventStreamReconnects.WithLabelValues(ctx.Runtime).Inc()
+				msgs, errs = c.ctx.ContainerdCli.EventService().Subscribe(containerCtx, "")
+				recovered = time.After(streamRecovery)
 			}
 		}
 	}()
@@ -101,6 +134,8 @@ func (c Containerd) processEvent(ctx *ctx.Context, containerCtx context.Context,
 	t := msg.Event.GetTypeUrl()
 	switch t {
 	case create:
+		defer observeEvent(ctx, "create", msg.Timestamp)
+		ctx.CancelRemoval(containerId)
 		if Exists(ctx, containerId) {
 			return nil
 		}
@@ -133,11 +168,12 @@ func (c Containerd) processEvent(ctx *ctx.Context, containerCtx context.Context,
 		return err
 
 	case delete:
+		defer observeEvent(ctx, "delete", msg.Timestamp)
 		logc.Infof(context.Background(), "Process container destroy event: %s", containerId)
 
-		err := DelContainerLogFile(ctx, containerId)
-		if err != nil {
-			logc.Errorf(context.Background(), fmt.Sprintf("Process container destroy event error: %s, %s", containerId, err.Error()))
+		// 等待采集器读取完容器最后的日志再删除配置
+		if Exists(ctx, containerId) {
+			RemoveContainer(ctx, containerId)
 		}
 	}
 	return nil
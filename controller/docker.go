@@ -7,9 +7,11 @@ import (
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/zeromicro/go-zero/core/logc"
-	"io"
+	"strconv"
 	"strings"
+	"time"
 	"watchlog/pkg/ctx"
+	"watchlog/pkg/metrics"
 )
 
 type Docker struct {
@@ -27,28 +29,35 @@ func (d *Docker) ProcessContainers() error {
 	d.ctx.Lock()
 	defer d.ctx.Unlock()
 
+	since := time.Now()
 	d.watchEvent(d.f)
 	containers, err := d.listContainers()
 	if err != nil {
 		return err
 	}
 
+	// 重新渲染全部容器的配置, 内容未变化的配置不会改写
+	running := make(map[string]bool)
 	for _, c := range containers {
 		if c.State == "removing" {
 			continue
 		}
-
-		if Exists(d.ctx, c.ID) {
-			continue
-		}
+		running[c.ID] = true
 
 		if err := d.processContainer(c.ID); err != nil {
 			logc.Errorf(context.Background(), fmt.Sprintf("Error processing container %s: %v", c.ID, err))
 		}
 	}
+	PruneConfigs(d.ctx, running, since)
+	d.ctx.SetSynced()
 	return nil
 }
 
+// Resync inspects the container again and regenerates its log config.
+func (d *Docker) Resync(id string) error {
+	return d.processContainer(id)
+}
+
 // listContainers retrieves the list of Docker containers.
 func (d *Docker) listContainers() ([]types.Container, error) {
 	opts := types.ContainerListOptions{}
@@ -90,24 +99,39 @@ func (d *Docker) processContainer(containerID string) error {
 	return NewCollectFile(d.ctx, fields)
 }
 
-// watchEvent listens for Docker events and processes them.
+// watchEvent listens for Docker events and processes them, the stream is resubscribed from the last event after errors.
 func (d *Docker) watchEvent(filter filters.Args) {
 	options := types.EventsOptions{Filters: filter}
 	msgs, errs := d.ctx.DockerCli.Events(d.ctx.Context, options)
 
 	go func() {
 		logc.Infof(context.Background(), "Beginning to watch docker events")
+		backoff := time.Second
+		var recovered <-chan time.Time
 		for {
 			select {
 			case msg := <-msgs:
+				backoff = time.Second
+				d.ctx.StreamUp()
+				// 重新订阅时从最后一个事件开始, 避免丢失断开期间的事件
+				options.Since = strconv.FormatInt(msg.Time, 10)
 				if err := d.processEvent(msg); err != nil {
 					logc.Errorf(context.Background(), fmt.Sprintf("Error processing event: %v", err))
 				}
+			case <-recovered:
+				recovered = nil
+				backoff = time.Second
+				d.ctx.StreamUp()
 			case err := <-errs:
-				logc.Errorf(context.Background(), fmt.Sprintf("Error in event stream: %v", err))
-				if err == io.EOF || err == io.ErrUnexpectedEOF {
-					return
+				d.ctx.StreamDown()
+				logc.Errorf(context.Background(), fmt.Sprintf("Error in event stream: %v, resubscribe in %s", err, backoff))
+				time.Sleep(backoff)
+				if backoff < 30*time.Second {
+					backoff *= 2
 				}
+				metrics.EventStreamReconnects.WithLabelValues(d.ctx.Runtime).Inc()
+				msgs, errs = d.ctx.DockerCli.Events(d.ctx.Context, options)
+				recovered = time.After(streamRecovery)
 			}
 		}
 	}()
@@ -118,8 +142,10 @@ func (d *Docker) processEvent(msg events.Message) error {
 	containerID := msg.Actor.ID
 	switch msg.Action {
 	case "start", "restart":
+		defer observeEvent(d.ctx, string(msg.Action), time.Unix(0, msg.TimeNano))
 		return d.handleStartRestartEvent(containerID)
 	case "destroy", "die":
+		defer observeEvent(d.ctx, string(msg.Action), time.Unix(0, msg.TimeNano))
 		return d.handleDestroyDieEvent(containerID)
 	default:
 		return nil
@@ -129,6 +155,8 @@ func (d *Docker) processEvent(msg events.Message) error {
 // handleStartRestartEvent processes container start/restart events.
 func (d *Docker) handleStartRestartEvent(containerID string) error {
 	logc.Debugf(context.Background(), "Processing container start/restart event: %s", containerID)
+	// 容器重新启动时保留等待删除的配置
+	d.ctx.CancelRemoval(containerID)
 	if Exists(d.ctx, containerID) {
 		logc.Debugf(context.Background(), "Container %s already exists, skipping", containerID)
 		return nil
@@ -143,5 +171,7 @@ func (d *Docker) handleDestroyDieEvent(containerID string) error {
 	}
 
 	logc.Debugf(context.Background(), "Processing container destroy event: %s", containerID)
-	return DelContainerLogFile(d.ctx, containerID)
+	// 等待采集器读取完容器最后的日志再删除配置
+	RemoveContainer(d.ctx, containerID)
+	return nil
 }
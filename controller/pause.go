@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/zeromicro/go-zero/core/logc"
+	logtypes "watchlog/log/config"
+	"watchlog/pkg/ctx"
+	"watchlog/pkg/metrics"
+)
+
+// Pause 暂停容器, Pod 或命名空间的采集, 返回暂停的容器
+//
+// 删除采集配置并停止原生采集, 采集器仓库及原生 registry 中的进度保留, 恢复后从暂停的位置继续采集.
+func Pause(ctx *ctx.Context, kind, value string) ([]string, error) {
+	value = containerID(ctx, kind, value)
+	p, added, err := ctx.AddPause(kind, value)
+	if err != nil {
+		return nil, err
+	}
+	if added {
+		logc.Infof(context.Background(), "Pause collection of %s %s", p.Kind, p.Value)
+	}
+
+	paused := make([]string, 0)
+	for _, c := range ctx.Containers() {
+		if c.Paused || !p.Match(c.ID, c.Labels) {
+			continue
+		}
+		if err := pauseContainer(ctx, c.ID, c.Labels, c.Configs, c.Native); err != nil {
+			return paused, err
+		}
+		paused = append(paused, c.ID)
+	}
+	return paused, nil
+}
+
+// Resume 恢复采集, 重新生成不再处于暂停范围内的容器的采集配置, 返回恢复的容器
+func Resume(ctx *ctx.Context, rt InterRuntime, kind, value string) ([]string, error) {
+	value = containerID(ctx, kind, value)
+	removed, err := ctx.RemovePause(kind, value)
+	if err != nil {
+		return nil, err
+	}
+	if !removed {
+		return nil, fmt.Errorf("%s %s is not paused", kind, value)
+	}
+	logc.Infof(context.Background(), "Resume collection of %s %s", kind, value)
+
+	resumed := make([]string, 0)
+	for _, c := range ctx.Containers() {
+		if !c.Paused || ctx.Paused(c.ID, c.Labels) != nil {
+			continue
+		}
+		if rt == nil {
+			return resumed, fmt.Errorf("container runtime is not configured")
+		}
+		if err := rt.Resync(c.ID); err != nil {
+			return resumed, fmt.Errorf("resume container %s failed, err: %s", c.ID, err.Error())
+		}
+		resumed = append(resumed, c.ID)
+	}
+	return resumed, nil
+}
+
+// containerID 暂停范围为容器时, 将唯一的 ID 前缀展开为完整的容器 ID
+func containerID(c *ctx.Context, kind, value string) string {
+	if kind != ctx.PauseContainer {
+		return value
+	}
+	if container, err := c.GetContainer(value); err == nil {
+		return container.ID
+	}
+	return value
+}
+
+// pauseContainer 删除容器的采集配置并记录为暂停
+func pauseContainer(c *ctx.Context, id string, labels map[string]string, configs []logtypes.LogConfig, native bool) error {
+	c.Tailer.Remove(id)
+	for _, p := range c.Pointers() {
+		g, err := p.RemoveConfig(id)
+		if err != nil {
+			return fmt.Errorf("removing %s log config of output %s failure, err: %s", id, p.Output, err.Error())
+		}
+		if g != nil {
+			metrics.ConfigsRemoved.WithLabelValues(c.Runtime, g.Namespace, p.Output).Inc()
+		}
+	}
+	c.TrackContainer(ctx.Container{
+		ID:      id,
+		Labels:  labels,
+		Configs: configs,
+		Native:  native,
+		Paused:  true,
+	})
+	return nil
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"context"
+	"github.com/zeromicro/go-zero/core/logc"
+	"os"
+	"time"
+	"watchlog/pkg/ctx"
+)
+
+const (
+	// defaultRemoveGrace 容器退出后等待日志采集完成的默认时长, 可通过 WATCHLOG_REMOVE_GRACE 修改
+	defaultRemoveGrace = 5 * time.Minute
+	removeInterval     = 5 * time.Second
+)
+
+func removeGrace() time.Duration {
+	v := os.Getenv("WATCHLOG_REMOVE_GRACE")
+	if v == "" {
+		return defaultRemoveGrace
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		logc.Errorf(context.Background(), "invalid WATCHLOG_REMOVE_GRACE %q, use %s", v, defaultRemoveGrace)
+		return defaultRemoveGrace
+	}
+	return d
+}
+
+// RemoveContainer 容器退出后等待采集器读取完日志文件再删除采集配置, 超过等待时长后直接删除
+func RemoveContainer(ctx *ctx.Context, id string) {
+	r := ctx.AddRemoval(id, removeGrace())
+	if r == nil {
+		return
+	}
+
+	logc.Infof(context.Background(), "Waiting for logs of container %s to be shipped before removing log config", id)
+	go func() {
+		for {
+			unshipped, err := Unshipped(ctx, id)
+			if err != nil {
+				logc.Errorf(context.Background(), "check shipping progress of container %s failed, err: %s", id, err.Error())
+			}
+			if !ctx.UpdateRemoval(r, unshipped) {
+				logc.Infof(context.Background(), "Container %s restarted, keep log config", id)
+				return
+			}
+			if err == nil && len(unshipped) == 0 {
+				break
+			}
+			if time.Now().After(r.Deadline) {
+				logc.Errorf(context.Background(), "logs of container %s are not fully shipped after %s, removing log config, unshipped: %v", id, r.Deadline.Sub(r.Since), unshipped)
+				break
+			}
+			time.Sleep(removeInterval)
+		}
+
+		if !ctx.DoneRemoval(r) {
+			return
+		}
+		if err := DelContainerLogFile(ctx, id); err != nil {
+			logc.Errorf(context.Background(), err.Error())
+		}
+	}()
+}
+
+// Unshipped 汇总容器日志文件中尚未采集完成的字节数, 多个输出采集同一文件时取最大值
+func Unshipped(ctx *ctx.Context, id string) (map[string]int64, error) {
+	ret := ctx.Tailer.Unshipped(id)
+	if ret == nil {
+		ret = make(map[string]int64)
+	}
+	for _, p := range ctx.Pointers() {
+		unshipped, err := p.Unshipped(id)
+		if err != nil {
+			return ret, err
+		}
+		for path, size := range unshipped {
+			if size > ret[path] {
+				ret[path] = size
+			}
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/zeromicro/go-zero/core/logc"
+	"watchlog/pkg/ctx"
+	"watchlog/pkg/pipeline"
+)
+
+// ReprocessPlan 重新采集容器日志时将重新读取的文件
+type ReprocessPlan struct {
+	Container string             `json:"container"`
+	Files     []pipeline.FileLag `json:"files"`
+	// Bytes 重新读取的总字节数, 执行前需要确认
+	Bytes int64 `json:"bytes"`
+}
+
+// FileLag 汇总原生采集器及各采集器仓库中的文件进度
+func FileLag(ctx *ctx.Context) []pipeline.FileLag {
+	files := ctx.Tailer.Lag()
+	for _, p := range ctx.Pointers() {
+		progress, err := p.Progress()
+		if err != nil {
+			logc.Errorf(context.Background(), "read registry of output %s failed, err: %s", p.Output, err.Error())
+			continue
+		}
+		for _, f := range progress {
+			lag := pipeline.NewFileLag(f.Container, p.Output, "filebeat", f.Path, f.Size, f.Offset, f.Updated)
+			lag.Namespace = f.Namespace
+			files = append(files, lag)
+		}
+	}
+	return files
+}
+
+// PlanReprocess 返回重新采集容器日志时将重新读取的文件及字节数
+func PlanReprocess(ctx *ctx.Context, id string) (ReprocessPlan, error) {
+	c, err := ctx.GetContainer(id)
+	if err != nil {
+		return ReprocessPlan{}, err
+	}
+	plan := ReprocessPlan{Container: c.ID, Files: make([]pipeline.FileLag, 0)}
+	for _, f := range FileLag(ctx) {
+		if f.Container == c.ID {
+			plan.Files = append(plan.Files, f)
+			plan.Bytes += f.Size
+		}
+	}
+	if len(plan.Files) == 0 {
+		return plan, fmt.Errorf("container %s has no log files", c.ID)
+	}
+	return plan, nil
+}
+
+// Reprocess 从头重新采集容器的日志文件, 用于输出配置错误导致数据丢失后补发
+//
+// Filebeat 类型的输出停止采集器, 从仓库删除文件的状态并使用新的 input ID 重新生成配置后启动;
+// 原生采集器将文件进度重置为 0.
+func Reprocess(ctx *ctx.Context, rt InterRuntime, id string) (ReprocessPlan, error) {
+	if rt == nil {
+		return ReprocessPlan{}, fmt.Errorf("container runtime is not configured")
+	}
+	plan, err := PlanReprocess(ctx, id)
+	if err != nil {
+		return plan, err
+	}
+	c, _ := ctx.GetContainer(plan.Container)
+
+	for _, p := range ctx.Pointers() {
+		if _, ok := c.ConfigPaths[p.Output]; !ok {
+			continue
+		}
+		var paths []string
+		for _, f := range plan.Files {
+			if f.Type == "filebeat" && f.Output == p.Output {
+				paths = append(paths, f.Path)
+			}
+		}
+		err := p.Restart(func() error {
+			n, err := p.ForgetFiles(paths)
+			if err != nil {
+				return fmt.Errorf("ForgetFiles failed, err: %s", err.Error())
+			}
+			if _, err := p.NextEpoch(c.ID); err != nil {
+				return fmt.Errorf("NextEpoch failed, err: %s", err.Error())
+			}
+			logc.Infof(context.Background(), "Removed %d registry states of container %s from output %s", n, c.ID, p.Output)
+			// 采集器启动前写入新的配置
+			return rt.Resync(c.ID)
+		})
+		if err != nil {
+			return plan, fmt.Errorf("reprocess container %s on output %s failed, err: %s", c.ID, p.Output, err.Error())
+		}
+	}
+
+	if c.Native && ctx.Tailer.Exists(c.ID) {
+		paths, err := ctx.Tailer.Reprocess(c.ID)
+		if err != nil {
+			return plan, fmt.Errorf("reprocess container %s on native outputs failed, err: %s", c.ID, err.Error())
+		}
+		logc.Infof(context.Background(), "Reset %d native file states of container %s", len(paths), c.ID)
+	}
+
+	logc.Infof(context.Background(), "Reprocessing %d bytes of container %s", plan.Bytes, c.ID)
+	return plan, nil
+}
@@ -3,18 +3,41 @@ package controller
 import (
 	"context"
 	"fmt"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/zeromicro/go-zero/core/logc"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 	logtypes "watchlog/log/config"
 	"watchlog/pkg/ctx"
+	"watchlog/pkg/metrics"
+	"watchlog/pkg/provider"
 	"watchlog/pkg/runtime"
 )
 
+// streamRecovery 重新订阅后持续该时间没有出错, 认为事件流已恢复
+const streamRecovery = 10 * time.Second
+
 type InterRuntime interface {
 	ProcessContainers() error
+	// Resync 重新读取容器信息并生成采集配置
+	Resync(id string) error
+}
+
+// NewRuntime 根据 RUNTIME_TYPE 创建容器运行时的控制器, 未指定时返回 nil
+func NewRuntime(c *ctx.Context) InterRuntime {
+	switch c.Runtime {
+	case "docker":
+		filter := filters.NewArgs()
+		filter.Add("type", "container")
+		return NewDockerInterface(c, filter)
+	case "containerd":
+		return NewContainerInterface(c)
+	default:
+		return nil
+	}
 }
 
 // Collect 判断是否需要收集日志
@@ -29,24 +52,72 @@ func Collect(env []string, logPrefix string) bool {
 	return exist
 }
 
-// Exists 判断采集容器日志的配置是否存在
+// Exists 判断采集容器日志的配置是否存在, 暂停采集的容器同样视为存在
 func Exists(ctx *ctx.Context, containId string) bool {
-	if _, err := os.Stat(ctx.FilebeatPointer.GetConfPath(containId)); os.IsNotExist(err) {
-		return false
+	if c, err := ctx.GetContainer(containId); err == nil && c.ID == containId && c.Paused {
+		return true
+	}
+	for _, p := range ctx.Pointers() {
+		if _, err := os.Stat(p.GetConfPath(containId)); err == nil {
+			return true
+		}
 	}
-	return true
+	return ctx.Tailer.Exists(containId)
 }
 
 // DelContainerLogFile 销毁采集容器日志文件
 func DelContainerLogFile(ctx *ctx.Context, id string) error {
 	logc.Infof(context.Background(), "Try removing log config %s", id)
-	if err := os.Remove(ctx.FilebeatPointer.GetConfPath(id)); err != nil {
-		return fmt.Errorf("removing %s log config failure, err: %s", id, err.Error())
+	removed := ctx.Tailer.Remove(id)
+	if c, err := ctx.GetContainer(id); err == nil && c.ID == id && c.Paused {
+		removed = true
+	}
+	for _, p := range ctx.Pointers() {
+		g, err := p.RemoveConfig(id)
+		if err != nil {
+			return fmt.Errorf("removing %s log config of output %s failure, err: %s", id, p.Output, err.Error())
+		}
+		if g != nil {
+			metrics.ConfigsRemoved.WithLabelValues(ctx.Runtime, g.Namespace, p.Output).Inc()
+			removed = true
+		}
 	}
 
+	ctx.UntrackContainer(id)
+	metrics.RateLimitedEvents.DeletePartialMatch(prometheus.Labels{"container": id})
+	metrics.RateLimitedBytes.DeletePartialMatch(prometheus.Labels{"container": id})
+	metrics.InvalidSequences.DeletePartialMatch(prometheus.Labels{"container": id})
+	if !removed {
+		return fmt.Errorf("removing %s log config failure, err: not found", id)
+	}
 	return nil
 }
 
+// PruneConfigs 启动同步完成后删除已不存在容器的采集配置, since 之后由事件写入的配置保留
+func PruneConfigs(ctx *ctx.Context, running map[string]bool, since time.Time) {
+	stale := make(map[string]bool)
+	for _, p := range ctx.Pointers() {
+		containers, err := p.StaleConfigs(running, since)
+		if err != nil {
+			logc.Errorf(context.Background(), "prune log configs of output %s failed, err: %s", p.Output, err.Error())
+			continue
+		}
+		for _, id := range containers {
+			stale[id] = true
+		}
+	}
+
+	// 容器在 WatchLog 重启期间退出, 同样等待日志采集完成后再删除
+	for id := range stale {
+		RemoveContainer(ctx, id)
+	}
+}
+
+// observeEvent 记录事件发生到处理完成的时间
+func observeEvent(ctx *ctx.Context, action string, at time.Time) {
+	metrics.EventLatency.WithLabelValues(ctx.Runtime, action).Observe(time.Since(at).Seconds())
+}
+
 type CollectFields struct {
 	Id      string
 	Env     []string
@@ -62,32 +133,129 @@ func NewCollectFile(ctx *ctx.Context, cf CollectFields) error {
 	jsonLogPath := cf.LogPath
 	ct := runtime.BuildContainerLabels(labels)
 	logEnvs := getLogEnvs(env)
+	namespace := labels[runtime.KubernetesContainerNamespace]
 
 	logPath := filepath.Join(ctx.BaseDir, jsonLogPath) // /host/var/lib/containerd/log/pods/intl_diagon-alley-5cf4c7cddc-7nd94_*/diagon-alley/*.log
 	logConfigs, err := logtypes.GetLogConfigs(ctx.LogPrefix, logPath, logEnvs)
 	if err != nil {
+		metrics.ConfigErrors.WithLabelValues(ctx.Runtime, namespace, "parse").Inc()
 		return fmt.Errorf("GetLogConfigs failed, err: %s", err.Error())
 	}
 
 	if len(logConfigs) == 0 {
 		return nil
 	}
+	// 限速只由原生输出执行并按容器统计丢弃, 日志设置的限速用于 Filebeat 类型的输出时不能忽略
+	for _, logConfig := range logConfigs {
+		if logConfig.RateLimit == nil {
+			continue
+		}
+		for _, output := range logConfig.Outputs {
+			if _, ok := ctx.FilebeatPointers[output]; ok {
+				metrics.ConfigErrors.WithLabelValues(ctx.Runtime, namespace, "parse").Inc()
+				return fmt.Errorf("log %s options _rate_limit and _rate_limit_bytes are not supported by filebeat output %s", logConfig.Name, output)
+			}
+		}
+	}
+	logtypes.ApplyMasks(logConfigs)
+	logtypes.ApplyRateLimits(logConfigs, namespace)
 
-	//生成 filebeat 采集配置
-	logConfig, err := ctx.FilebeatPointer.RenderLogConfig(id, ct, logConfigs)
-	if err != nil {
-		return fmt.Errorf("RenderLogConfig failed, err: %s", err.Error())
+	// 按输出拆分, Filebeat 类型的输出写入对应采集器的配置目录, 原生类型的输出由原生采集器读取
+	outputs := make(map[string][]logtypes.LogConfig)
+	var native []logtypes.LogConfig
+	for _, logConfig := range logConfigs {
+		isNative := false
+		for _, output := range logConfig.Outputs {
+			if _, ok := ctx.FilebeatPointers[output]; ok {
+				outputs[output] = append(outputs[output], logConfig)
+				continue
+			}
+			if !ctx.Tailer.HasOutput(output) {
+				metrics.ConfigErrors.WithLabelValues(ctx.Runtime, namespace, "parse").Inc()
+				return fmt.Errorf("log %s output %s is not declared in LOGGING_OUTPUTS", logConfig.Name, output)
+			}
+			isNative = true
+		}
+		if isNative {
+			native = append(native, logConfig)
+		}
 	}
 
-	//TODO validate config before save
-	logc.Infof(context.Background(), fmt.Sprintf("Write Log config, path: %s", ctx.FilebeatPointer.GetConfPath(id)))
-	if err = ioutil.WriteFile(ctx.FilebeatPointer.GetConfPath(id), []byte(logConfig), os.FileMode(0644)); err != nil {
-		return fmt.Errorf("WriteFile failed, err: %s", err.Error())
+	// 暂停期间不生成采集配置, 恢复后从暂停的位置继续采集
+	if pause := ctx.Paused(id, ct); pause != nil {
+		logc.Infof(context.Background(), "Collection of container %s is paused by %s %s", id, pause.Kind, pause.Value)
+		return pauseContainer(ctx, id, ct, logConfigs, len(native) > 0)
+	}
+
+	// 全部输出的配置校验通过后再写入, 校验失败时保留上一次的配置
+	rendered := make(map[string]string)
+	for _, p := range ctx.Pointers() {
+		configs, ok := outputs[p.Output]
+		if !ok {
+			continue
+		}
+
+		//生成 filebeat 采集配置
+		logConfig, err := p.RenderLogConfig(id, ct, configs)
+		if err != nil {
+			metrics.ConfigErrors.WithLabelValues(ctx.Runtime, namespace, "render").Inc()
+			return fmt.Errorf("RenderLogConfig failed, err: %s", err.Error())
+		}
+
+		if err := provider.ValidateConfig([]byte(logConfig)); err != nil {
+			metrics.ConfigErrors.WithLabelValues(ctx.Runtime, namespace, "validate").Inc()
+			return fmt.Errorf("container %s log config of output %s is invalid, err: %s", id, p.Output, err.Error())
+		}
+		rendered[p.Output] = logConfig
 	}
 
+	for _, p := range ctx.Pointers() {
+		logConfig, ok := rendered[p.Output]
+		if !ok {
+			continue
+		}
+
+		// 先写临时文件再重命名, 内容未变化时不改写, 避免采集器重复加载
+		written, err := p.WriteConfig(id, namespace, []byte(logConfig))
+		if err != nil {
+			metrics.ConfigErrors.WithLabelValues(ctx.Runtime, namespace, "write").Inc()
+			return fmt.Errorf("WriteFile failed, err: %s", err.Error())
+		}
+		if written {
+			metrics.ConfigsWritten.WithLabelValues(ctx.Runtime, namespace, p.Output).Inc()
+			logc.Infof(context.Background(), fmt.Sprintf("Write Log config, path: %s", p.GetConfPath(id)))
+		} else {
+			logc.Debugf(context.Background(), "Log config unchanged, path: %s", p.GetConfPath(id))
+		}
+	}
+
+	if len(native) > 0 {
+		if err := ctx.Tailer.Add(id, ct, native); err != nil {
+			return fmt.Errorf("add native collect failed, err: %s", err.Error())
+		}
+	}
+
+	trackContainer(ctx, id, ct, logConfigs, len(native) > 0)
 	return nil
 }
 
+// trackContainer 记录容器的采集配置, 供管理接口查询
+func trackContainer(c *ctx.Context, id string, labels map[string]string, configs []logtypes.LogConfig, native bool) {
+	paths := make(map[string]string)
+	for _, p := range c.Pointers() {
+		if _, err := os.Stat(p.GetConfPath(id)); err == nil {
+			paths[p.Output] = p.GetConfPath(id)
+		}
+	}
+	c.TrackContainer(ctx.Container{
+		ID:          id,
+		Labels:      labels,
+		Configs:     configs,
+		ConfigPaths: paths,
+		Native:      native,
+	})
+}
+
 // getLogEnvs 获取关键 Envs
 func getLogEnvs(env []string) map[string]string {
 	var logEnv = map[string]string{} //  map[aliyun_logs_tencent-prod-diagon-alley:stdout]
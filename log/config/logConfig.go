@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -17,35 +18,126 @@ type LogConfig struct {
 	Tags         map[string]string
 	EstimateTime bool
 	Stdout       bool
+	Stream       string
+	Multiline    *Multiline
+	IncludeLines []string
+	ExcludeLines []string
+	TailFiles    bool
+	IgnoreOlder  string
+	Encoding     string
+	RateLimit    *RateLimit
+	Mask         []string
+	Sample       *Sample
+	Outputs      []string
+}
+
+// Sample 按级别采样, 每 Rates[level] 行保留 1 行
+type Sample struct {
+	Rates map[string]int
+	// Fields JSON 日志中的级别字段, 为空时使用 tools.DefaultSampleFields
+	Fields []string
+	// Pattern 匹配级别的正则, 为空时使用 tools.DefaultSamplePattern
+	Pattern string
+}
+
+// Multiline 多行合并配置
+type Multiline struct {
+	Pattern string
+	Negate  bool
+	Match   string
 }
 
 const LabelServiceLogsTmpl = "%s_"
 
+// DefaultOutput 未通过 _output 选项指定输出时使用的输出
+const DefaultOutput = "default"
+
+// 容器日志中记录的输出流
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+	StreamAll    = "all"
+)
+
+// GetLogConfigs 解析容器的日志采集 Env
+//
+// watchlog_{name}=stdout 声明一个日志, watchlog_{name}_{option}=value 为该日志设置选项,
+// 按最长匹配查找已注册的选项后缀, 之前的部分为日志名称, 没有匹配的后缀时整体为日志名称,
+// 因此日志名称可以包含下划线, 例如 watchlog_my_app=stdout 及 watchlog_my_app_tail=true.
 func GetLogConfigs(logPrefix string, jsonLogPath string, labels map[string]string) ([]LogConfig, error) {
-	var ret []LogConfig
-	for label, _ := range labels {
-		p := fmt.Sprintf(LabelServiceLogsTmpl, logPrefix)
+	p := fmt.Sprintf(LabelServiceLogsTmpl, logPrefix)
+
+	logs := make(map[string]string)
+	opts := make(map[string]map[string]string)
+	for label, value := range labels {
+		if !strings.HasPrefix(label, p) {
+			continue
+		}
+
 		logTopicName := strings.TrimPrefix(label, p) // watchlog_default, logTopicName = default
-		logConfig, err := parseLogConfig(logTopicName, labels[label], jsonLogPath)
+		name, suffix := splitOption(logTopicName)
+		if suffix == "" {
+			logs[name] = value
+			continue
+		}
+
+		if opts[name] == nil {
+			opts[name] = make(map[string]string)
+		}
+		opts[name][suffix] = value
+	}
+
+	for name := range opts {
+		if _, ok := logs[name]; !ok {
+			return nil, fmt.Errorf("env %s%s_* sets options for undeclared log %s", p, name, name)
+		}
+	}
+
+	var ret []LogConfig
+	for _, name := range sortedKeys(logs) {
+		logConfig, err := parseLogConfig(name, logs[name], jsonLogPath)
 		if err != nil {
+			// 拼错的选项后缀会被当作日志名称的一部分
+			if strings.Contains(name, "_") {
+				return nil, fmt.Errorf("%s, or an unknown option suffix, supported: %s", err.Error(), strings.Join(optionSuffixes(), ", "))
+			}
 			return nil, err
 		}
+
+		for _, suffix := range sortedKeys(opts[name]) {
+			if err := options[suffix](&logConfig, opts[name][suffix]); err != nil {
+				return nil, fmt.Errorf("env %s%s_%s invalid: %s", p, name, suffix, err.Error())
+			}
+		}
+		if err := validateOptions(&logConfig); err != nil {
+			return nil, fmt.Errorf("env %s%s_* invalid: %s", p, name, err.Error())
+		}
 		ret = append(ret, logConfig)
 	}
 	return ret, nil
 }
 
-//func getLabelNames(logPrefix string, labels map[string]string) []string {
-//	var labelNames []string
-//	for k := range labels {
-//		if strings.HasPrefix(k, logPrefix) {
-//			labelNames = append(labelNames, k)
-//		}
-//	}
-//	//sort keys
-//	sort.Strings(labelNames)
-//	return labelNames
-//}
+// splitOption 按最长匹配拆分日志名称及选项后缀, 例如 my_app_rate_limit_bytes 拆分为 my_app 及 rate_limit_bytes
+func splitOption(logTopicName string) (string, string) {
+	name, suffix := logTopicName, ""
+	for s := range options {
+		n := strings.TrimSuffix(logTopicName, "_"+s)
+		if n == logTopicName || n == "" || len(s) <= len(suffix) {
+			continue
+		}
+		name, suffix = n, s
+	}
+	return name, suffix
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
 
 func parseLogConfig(label, value string, jsonLogPath string) (LogConfig, error) {
 	cfg := new(LogConfig)
@@ -53,18 +145,24 @@ func parseLogConfig(label, value string, jsonLogPath string) (LogConfig, error)
 		return *cfg, fmt.Errorf("env %s value don't is null", label)
 	}
 
-	// 标准输出日志
-	if value == "stdout" {
+	// 容器标准输出日志, stdout / stderr 仅采集对应的流, all 采集全部
+	switch value {
+	case StreamStdout, StreamStderr, StreamAll:
 		logFile := filepath.Base(jsonLogPath) + "*"
 		cfg = &LogConfig{
 			File:    logFile,
 			Name:    label,
 			HostDir: filepath.Dir(jsonLogPath),
+			Stdout:  true,
+			Stream:  value,
+			Outputs: []string{DefaultOutput},
 			Tags: map[string]string{
 				"index": label,
 				"topic": label,
 			},
 		}
+	default:
+		return *cfg, fmt.Errorf("env %s value %s is unsupported, supported: %s, %s, %s", label, value, StreamStdout, StreamStderr, StreamAll)
 	}
 	return *cfg, nil
 }
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitOption(t *testing.T) {
+	cases := []struct {
+		in     string
+		name   string
+		suffix string
+	}{
+		{"app", "app", ""},
+		{"my_app", "my_app", ""},
+		{"my_app_tail", "my_app", "tail"},
+		{"app_multiline", "app", "multiline"},
+		{"app_multiline_pattern", "app", "multiline_pattern"},
+		{"my_app_rate_limit", "my_app", "rate_limit"},
+		{"my_app_rate_limit_bytes", "my_app", "rate_limit_bytes"},
+		{"app_sample_field", "app", "sample_field"},
+		// 只有后缀时不是选项
+		{"tail", "tail", ""},
+	}
+	for _, c := range cases {
+		name, suffix := splitOption(c.in)
+		if name != c.name || suffix != c.suffix {
+			t.Errorf("splitOption(%q) = %q, %q, want %q, %q", c.in, name, suffix, c.name, c.suffix)
+		}
+	}
+}
+
+func TestGetLogConfigsUnderscoreName(t *testing.T) {
+	configs, err := GetLogConfigs("watchlog", "/var/lib/docker/containers/c1/c1-json.log", map[string]string{
+		"watchlog_my_app":              "stdout",
+		"watchlog_my_app_tail":         "true",
+		"watchlog_my_app_ignore_older": "24h",
+		"watchlog_audit":               "stderr",
+		"PATH":                         "/usr/bin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("got %d configs, want 2", len(configs))
+	}
+	audit, app := configs[0], configs[1]
+	if audit.Name != "audit" || audit.Stream != StreamStderr || audit.TailFiles {
+		t.Errorf("audit config = %+v", audit)
+	}
+	if app.Name != "my_app" || app.Stream != StreamStdout || !app.TailFiles || app.IgnoreOlder != "24h" {
+		t.Errorf("my_app config = %+v", app)
+	}
+	if !reflect.DeepEqual(app.Outputs, []string{DefaultOutput}) {
+		t.Errorf("my_app outputs = %v, want [%s]", app.Outputs, DefaultOutput)
+	}
+
+	// 拼错的选项被当作日志声明, 值不是输出流时报错
+	if _, err := GetLogConfigs("watchlog", "/c1-json.log", map[string]string{
+		"watchlog_app":      "stdout",
+		"watchlog_app_tial": "true",
+	}); err == nil {
+		t.Error("GetLogConfigs with a misspelled option should fail")
+	}
+	// 未声明的日志不能设置选项
+	if _, err := GetLogConfigs("watchlog", "/c1-json.log", map[string]string{
+		"watchlog_app_tail": "true",
+	}); err == nil {
+		t.Error("GetLogConfigs with options of an undeclared log should fail")
+	}
+}
+
+func TestSampleOptions(t *testing.T) {
+	configs, err := GetLogConfigs("watchlog", "/c1-json.log", map[string]string{
+		"watchlog_app":                "stdout",
+		"watchlog_app_sample_pattern": `^\[(\w+)\]`,
+		"watchlog_app_sample_field":   "lvl",
+		"watchlog_app_sample":         "info=10",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Sample{Rates: map[string]int{"info": 10}, Fields: []string{"lvl"}, Pattern: `^\[(\w+)\]`}
+	if !reflect.DeepEqual(configs[0].Sample, want) {
+		t.Errorf("sample = %+v, want %+v", configs[0].Sample, want)
+	}
+
+	// 依赖在全部选项设置后校验, _sample 在后面设置时同样有效
+	cfg := LogConfig{}
+	for _, o := range [][2]string{{"sample_pattern", `level=(\w+)`}, {"sample_field", "lvl"}, {"sample", "5"}} {
+		if err := options[o[0]](&cfg, o[1]); err != nil {
+			t.Fatalf("option %s: %v", o[0], err)
+		}
+	}
+	if err := validateOptions(&cfg); err != nil || cfg.Sample.Rates["debug"] != 5 {
+		t.Errorf("validateOptions = %v, sample %+v", err, cfg.Sample)
+	}
+
+	// 没有 _sample 时报错
+	if _, err := GetLogConfigs("watchlog", "/c1-json.log", map[string]string{
+		"watchlog_app":              "stdout",
+		"watchlog_app_sample_field": "lvl",
+	}); err == nil {
+		t.Error("GetLogConfigs with _sample_field but no _sample should fail")
+	}
+	// JavaScript 不支持的正则语法报错
+	for _, pattern := range []string{`(?i)level=(\w+)`, `level=([[:alpha:]]+)`, `(?P<level>\w+)`} {
+		if err := options["sample_pattern"](&LogConfig{}, pattern); err == nil {
+			t.Errorf("sample_pattern %q should fail", pattern)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"strings"
+
+	"watchlog/pkg/tools"
+)
+
+// globalMasks 全部日志使用的脱敏规则, 由 WATCHLOG_MASK 设置
+var globalMasks []string
+
+// parseMasks 解析逗号分隔的脱敏规则名称并校验
+func parseMasks(value string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if _, err := tools.GetMask(name); err != nil {
+			return nil, err
+		}
+		names = appendUnique(names, name)
+	}
+	return names, nil
+}
+
+// LoadGlobalMasks sets the mask patterns applied to every log, e.g. pci,email
+func LoadGlobalMasks(value string) error {
+	names, err := parseMasks(value)
+	if err != nil {
+		return err
+	}
+	globalMasks = names
+	return nil
+}
+
+// ApplyMasks 在日志自身的脱敏规则之前加入全局规则
+func ApplyMasks(configs []LogConfig) {
+	if len(globalMasks) == 0 {
+		return
+	}
+	for i := range configs {
+		names := append([]string{}, globalMasks...)
+		for _, name := range configs[i].Mask {
+			names = appendUnique(names, name)
+		}
+		configs[i].Mask = names
+	}
+}
+
+func appendUnique(names []string, name string) []string {
+	for _, n := range names {
+		if n == name {
+			return names
+		}
+	}
+	return append(names, name)
+}
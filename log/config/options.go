@@ -0,0 +1,231 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"watchlog/pkg/tools"
+)
+
+// OptionParser applies an option env value to the log config
+type OptionParser func(cfg *LogConfig, value string) error
+
+var options = make(map[string]OptionParser)
+
+// RegisterOption register log option parser by env suffix, e.g. tags for watchlog_{name}_tags
+func RegisterOption(suffix string, parser OptionParser) {
+	options[suffix] = parser
+}
+
+func optionSuffixes() []string {
+	var suffixes []string
+	for suffix := range options {
+		suffixes = append(suffixes, "_"+suffix)
+	}
+	sort.Strings(suffixes)
+	return suffixes
+}
+
+func init() {
+	// team=pay,env=prod
+	RegisterOption("tags", func(cfg *LogConfig, value string) error {
+		if cfg.Tags == nil {
+			cfg.Tags = make(map[string]string)
+		}
+		for _, kv := range strings.Split(value, ",") {
+			k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+			if !ok || k == "" || v == "" {
+				return fmt.Errorf("tag %q must be key=value", kv)
+			}
+			cfg.Tags[k] = v
+		}
+		return nil
+	})
+
+	// java, python, go, dotnet 或通过 MULTILINE_PRESETS_FILE 注册的预设
+	RegisterOption("multiline", func(cfg *LogConfig, value string) error {
+		preset, err := tools.GetMultiline(value)
+		if err != nil {
+			return err
+		}
+		cfg.Multiline = &Multiline{Pattern: preset.Pattern, Negate: preset.Negate, Match: preset.Match}
+		return nil
+	})
+
+	RegisterOption("multiline_pattern", func(cfg *LogConfig, value string) error {
+		if err := validateRegexp(value); err != nil {
+			return err
+		}
+		// 不匹配 pattern 的行追加到上一个匹配行之后
+		cfg.Multiline = &Multiline{Pattern: value, Negate: true, Match: "after"}
+		return nil
+	})
+
+	RegisterOption("include_lines", func(cfg *LogConfig, value string) error {
+		if err := validateRegexp(value); err != nil {
+			return err
+		}
+		cfg.IncludeLines = append(cfg.IncludeLines, value)
+		return nil
+	})
+
+	RegisterOption("exclude_lines", func(cfg *LogConfig, value string) error {
+		if err := validateRegexp(value); err != nil {
+			return err
+		}
+		cfg.ExcludeLines = append(cfg.ExcludeLines, value)
+		return nil
+	})
+
+	RegisterOption("tail", func(cfg *LogConfig, value string) error {
+		tail, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a bool", value)
+		}
+		cfg.TailFiles = tail
+		return nil
+	})
+
+	RegisterOption("ignore_older", func(cfg *LogConfig, value string) error {
+		if _, err := time.ParseDuration(value); err != nil {
+			return fmt.Errorf("%q is not a duration, e.g. 24h", value)
+		}
+		cfg.IgnoreOlder = value
+		return nil
+	})
+
+	// 输出名称, 多个使用逗号分隔时同时发送到每个输出
+	RegisterOption("output", func(cfg *LogConfig, value string) error {
+		var outputs []string
+		for _, output := range strings.Split(value, ",") {
+			output = strings.TrimSpace(output)
+			if err := ValidateOutputName(output); err != nil {
+				return err
+			}
+			outputs = append(outputs, output)
+		}
+		cfg.Outputs = outputs
+		return nil
+	})
+
+	// 脱敏规则, 多个使用逗号分隔, 内置 pci, email, phone, bearer 及通过 MASK_PATTERNS_FILE 注册的规则
+	RegisterOption("mask", func(cfg *LogConfig, value string) error {
+		names, err := parseMasks(value)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			cfg.Mask = appendUnique(cfg.Mask, name)
+		}
+		return nil
+	})
+
+	// 10 保留 1/10 的 trace, debug, info 行, 或按级别 info=10,debug=100, 其余级别全部保留
+	RegisterOption("sample", func(cfg *LogConfig, value string) error {
+		rates, err := tools.ParseSampleRates(value)
+		if err != nil {
+			return err
+		}
+		if cfg.Sample == nil {
+			cfg.Sample = &Sample{}
+		}
+		cfg.Sample.Rates = rates
+		return nil
+	})
+
+	// JSON 日志的级别字段, 多个使用逗号分隔, 支持 . 分隔的嵌套字段, 需要同时设置 _sample
+	RegisterOption("sample_field", func(cfg *LogConfig, value string) error {
+		if cfg.Sample == nil {
+			cfg.Sample = &Sample{}
+		}
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				cfg.Sample.Fields = append(cfg.Sample.Fields, field)
+			}
+		}
+		return nil
+	})
+
+	// 匹配级别的正则, 取第一个非空的分组, 没有分组时取整个匹配, 需要同时设置 _sample.
+	// Filebeat 类型的输出由 JavaScript 执行, 只能使用 Go 与 JavaScript 共同支持的语法
+	RegisterOption("sample_pattern", func(cfg *LogConfig, value string) error {
+		if err := validateRegexp(value); err != nil {
+			return err
+		}
+		if err := tools.ValidateScriptRegexp(value); err != nil {
+			return err
+		}
+		if cfg.Sample == nil {
+			cfg.Sample = &Sample{}
+		}
+		cfg.Sample.Pattern = value
+		return nil
+	})
+
+	// 每秒事件数, 100/s, 6000/m 或 100, 仅原生输出支持, 用于 Filebeat 类型的输出时生成采集配置失败
+	RegisterOption("rate_limit", func(cfg *LogConfig, value string) error {
+		rate, err := ParseRate(value)
+		if err != nil {
+			return err
+		}
+		if cfg.RateLimit == nil {
+			cfg.RateLimit = &RateLimit{}
+		}
+		cfg.RateLimit.Events = rate
+		return nil
+	})
+
+	// 每秒字节数, 1MiB 或 1MiB/s, 仅原生输出支持, 用于 Filebeat 类型的输出时生成采集配置失败
+	RegisterOption("rate_limit_bytes", func(cfg *LogConfig, value string) error {
+		rate, err := ParseByteRate(value)
+		if err != nil {
+			return err
+		}
+		if cfg.RateLimit == nil {
+			cfg.RateLimit = &RateLimit{}
+		}
+		cfg.RateLimit.Bytes = rate
+		return nil
+	})
+
+	RegisterOption("encoding", func(cfg *LogConfig, value string) error {
+		encoding := strings.ToLower(value)
+		if err := tools.ValidateEncoding(encoding); err != nil {
+			return err
+		}
+		cfg.Encoding = encoding
+		return nil
+	})
+}
+
+// outputName 输出名称同时用于目录及环境变量前缀
+var outputName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// ValidateOutputName checks the output name can be used in paths and env prefixes
+func ValidateOutputName(name string) error {
+	if !outputName.MatchString(name) {
+		return fmt.Errorf("output name %q must match %s", name, outputName.String())
+	}
+	return nil
+}
+
+// validateOptions 校验选项之间的依赖, 在日志的全部选项设置后调用, 与选项的顺序无关
+func validateOptions(cfg *LogConfig) error {
+	if cfg.Sample != nil && len(cfg.Sample.Rates) == 0 {
+		return fmt.Errorf("_sample_field and _sample_pattern require the _sample option")
+	}
+	return nil
+}
+
+func validateRegexp(value string) error {
+	if value == "" {
+		return fmt.Errorf("regex pattern can not be empty")
+	}
+	if _, err := regexp.Compile(value); err != nil {
+		return fmt.Errorf("invalid regex %q: %s", value, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/go-ucfg"
+	"github.com/elastic/go-ucfg/yaml"
+	"watchlog/pkg/tools"
+)
+
+// RateLimit 日志的限速, 超过限制的事件被丢弃, 0 表示不限制. 仅原生输出支持
+type RateLimit struct {
+	// Events 每秒事件数
+	Events float64
+	// Bytes 每秒字节数
+	Bytes int64
+}
+
+// RateLimitSpec 默认限速的配置, Events 格式同 _rate_limit, Bytes 格式同 _rate_limit_bytes
+type RateLimitSpec struct {
+	Events string `config:"events"`
+	Bytes  string `config:"bytes"`
+}
+
+// logRateLimits 节点及命名空间中每个日志的默认限速, 日志未设置限速时使用.
+// 命名空间的限速不是整个命名空间共用的总限速, 命名空间中的每个日志分别按该限速计算
+var logRateLimits = struct {
+	node       RateLimit
+	namespaces map[string]RateLimit
+}{namespaces: make(map[string]RateLimit)}
+
+// rateUnits 限速的时间单位, 与 Filebeat rate_limit 处理器一致
+var rateUnits = map[string]float64{"s": 1, "m": 60, "h": 3600}
+
+// ParseRate parses an event rate like 100/s, 6000/m or 100 (per second) into events per second
+func ParseRate(value string) (float64, error) {
+	n, unit, ok := strings.Cut(strings.TrimSpace(value), "/")
+	if !ok {
+		unit = "s"
+	}
+	per, exist := rateUnits[unit]
+	if !exist {
+		return 0, fmt.Errorf("rate %q unit must be s, m or h", value)
+	}
+	rate, err := strconv.ParseFloat(n, 64)
+	if err != nil || rate < 0 {
+		return 0, fmt.Errorf("rate %q must be a non-negative number, e.g. 100/s", value)
+	}
+	return rate / per, nil
+}
+
+// ParseByteRate parses a byte rate like 1MiB or 1MiB/s into bytes per second
+func ParseByteRate(value string) (int64, error) {
+	size := strings.TrimSuffix(strings.TrimSpace(value), "/s")
+	n, err := tools.ParseSize(size)
+	if err != nil {
+		return 0, fmt.Errorf("byte rate %q must be a size per second, e.g. 1MiB", value)
+	}
+	return n, nil
+}
+
+func (s RateLimitSpec) parse() (RateLimit, error) {
+	var (
+		r   RateLimit
+		err error
+	)
+	if s.Events != "" {
+		if r.Events, err = ParseRate(s.Events); err != nil {
+			return r, err
+		}
+	}
+	if s.Bytes != "" {
+		if r.Bytes, err = ParseByteRate(s.Bytes); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+// Validate checks the events and bytes of the spec
+func (s RateLimitSpec) Validate() error {
+	_, err := s.parse()
+	return err
+}
+
+// LoadRateLimits 加载每个日志的默认限速 WATCHLOG_RATE_LIMIT / WATCHLOG_RATE_LIMIT_BYTES,
+// path 非空时加载 yaml 中节点及命名空间内每个日志的默认限速
+//
+//	default:
+//	  events: 1000/s
+//	  bytes: 5MiB
+//	namespaces:
+//	  batch:
+//	    events: 100/s
+func LoadRateLimits(path string) error {
+	var file struct {
+		Default    RateLimitSpec            `config:"default"`
+		Namespaces map[string]RateLimitSpec `config:"namespaces"`
+	}
+	if path != "" {
+		c, err := yaml.NewConfigWithFile(path, ucfg.PathSep("."))
+		if err != nil {
+			return fmt.Errorf("read rate limits %s failed, err: %s", path, err.Error())
+		}
+		// Unpack 会调用 RateLimitSpec.Validate 校验每个限速
+		if err := c.Unpack(&file); err != nil {
+			return fmt.Errorf("parse rate limits %s failed, err: %s", path, err.Error())
+		}
+	}
+
+	// 环境变量优先于文件中的默认限速
+	if v := os.Getenv("WATCHLOG_RATE_LIMIT"); v != "" {
+		file.Default.Events = v
+	}
+	if v := os.Getenv("WATCHLOG_RATE_LIMIT_BYTES"); v != "" {
+		file.Default.Bytes = v
+	}
+	node, err := file.Default.parse()
+	if err != nil {
+		return fmt.Errorf("invalid default rate limit: %s", err.Error())
+	}
+
+	namespaces := make(map[string]RateLimit, len(file.Namespaces))
+	for ns, spec := range file.Namespaces {
+		if namespaces[ns], err = spec.parse(); err != nil {
+			return fmt.Errorf("invalid rate limit of namespace %s: %s", ns, err.Error())
+		}
+	}
+	logRateLimits.node = node
+	logRateLimits.namespaces = namespaces
+	return nil
+}
+
+// ApplyRateLimits 为未设置限速的日志填充命名空间及节点中每个日志的默认限速, 优先级为日志选项 > 命名空间 > 节点
+func ApplyRateLimits(configs []LogConfig, namespace string) {
+	ns := logRateLimits.namespaces[namespace]
+	for i := range configs {
+		r := RateLimit{}
+		if configs[i].RateLimit != nil {
+			r = *configs[i].RateLimit
+		}
+		for _, d := range []RateLimit{ns, logRateLimits.node} {
+			if r.Events == 0 {
+				r.Events = d.Events
+			}
+			if r.Bytes == 0 {
+				r.Bytes = d.Bytes
+			}
+		}
+		if r.Events == 0 && r.Bytes == 0 {
+			configs[i].RateLimit = nil
+			continue
+		}
+		configs[i].RateLimit = &r
+	}
+}
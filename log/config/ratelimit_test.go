@@ -0,0 +1,201 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseRate(t *testing.T) {
+	cases := []struct {
+		in   string
+		want float64
+		err  bool
+	}{
+		{"100", 100, false},
+		{"100/s", 100, false},
+		{" 6000/m ", 100, false},
+		{"3600/h", 1, false},
+		{"30/m", 0.5, false},
+		{"1.5/s", 1.5, false},
+		{"0", 0, false},
+		{"100/d", 0, true},
+		{"-1/s", 0, true},
+		{"abc", 0, true},
+		{"", 0, true},
+	}
+	for _, c := range cases {
+		got, err := ParseRate(c.in)
+		if (err != nil) != c.err {
+			t.Errorf("ParseRate(%q) err = %v, want error %v", c.in, err, c.err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("ParseRate(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseByteRate(t *testing.T) {
+	cases := []struct {
+		in   string
+		want int64
+		err  bool
+	}{
+		{"1024", 1024, false},
+		{"1MiB", 1 << 20, false},
+		{"1MiB/s", 1 << 20, false},
+		{"10KB", 10000, false},
+		{" 512kib/s ", 512 << 10, false},
+		{"1MiB/m", 0, true},
+		{"-1", 0, true},
+		{"fast", 0, true},
+	}
+	for _, c := range cases {
+		got, err := ParseByteRate(c.in)
+		if (err != nil) != c.err {
+			t.Errorf("ParseByteRate(%q) err = %v, want error %v", c.in, err, c.err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("ParseByteRate(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+// setRateLimits 替换默认限速, 测试结束后恢复
+func setRateLimits(t *testing.T, node RateLimit, namespaces map[string]RateLimit) {
+	t.Helper()
+	saved := logRateLimits
+	t.Cleanup(func() { logRateLimits = saved })
+	logRateLimits.node = node
+	logRateLimits.namespaces = namespaces
+}
+
+func TestApplyRateLimits(t *testing.T) {
+	setRateLimits(t, RateLimit{Events: 1000, Bytes: 5 << 20}, map[string]RateLimit{
+		"batch": {Events: 100},
+		"quiet": {Events: 10, Bytes: 1024},
+	})
+	cases := []struct {
+		name      string
+		namespace string
+		limit     *RateLimit
+		want      *RateLimit
+	}{
+		{"node", "default", nil, &RateLimit{Events: 1000, Bytes: 5 << 20}},
+		// 命名空间未设置字节数时使用节点的字节数
+		{"namespace", "batch", nil, &RateLimit{Events: 100, Bytes: 5 << 20}},
+		{"namespace both", "quiet", nil, &RateLimit{Events: 10, Bytes: 1024}},
+		{"log events", "batch", &RateLimit{Events: 5}, &RateLimit{Events: 5, Bytes: 5 << 20}},
+		{"log bytes", "quiet", &RateLimit{Bytes: 64}, &RateLimit{Events: 10, Bytes: 64}},
+		{"log both", "quiet", &RateLimit{Events: 1, Bytes: 1}, &RateLimit{Events: 1, Bytes: 1}},
+	}
+	for _, c := range cases {
+		configs := []LogConfig{{Name: "app", RateLimit: c.limit}}
+		ApplyRateLimits(configs, c.namespace)
+		if !reflect.DeepEqual(configs[0].RateLimit, c.want) {
+			t.Errorf("%s: rate limit = %+v, want %+v", c.name, configs[0].RateLimit, c.want)
+		}
+	}
+
+	// 没有任何默认限速时不限速
+	setRateLimits(t, RateLimit{}, map[string]RateLimit{})
+	configs := []LogConfig{{Name: "app"}, {Name: "audit", RateLimit: &RateLimit{Events: 1}}}
+	ApplyRateLimits(configs, "default")
+	if configs[0].RateLimit != nil {
+		t.Errorf("rate limit without defaults = %+v, want nil", configs[0].RateLimit)
+	}
+	if !reflect.DeepEqual(configs[1].RateLimit, &RateLimit{Events: 1}) {
+		t.Errorf("log rate limit = %+v, want events 1", configs[1].RateLimit)
+	}
+}
+
+func TestLoadRateLimits(t *testing.T) {
+	setRateLimits(t, RateLimit{}, map[string]RateLimit{})
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	valid := write("limits.yaml", `
+default:
+  events: 1000/s
+  bytes: 5MiB
+namespaces:
+  batch:
+    events: 6000/m
+  quiet:
+    bytes: 1KiB/s
+`)
+
+	cases := []struct {
+		name       string
+		path       string
+		env        map[string]string
+		node       RateLimit
+		namespaces map[string]RateLimit
+		err        bool
+	}{
+		{name: "empty", node: RateLimit{}, namespaces: map[string]RateLimit{}},
+		{
+			name:       "env only",
+			env:        map[string]string{"WATCHLOG_RATE_LIMIT": "50", "WATCHLOG_RATE_LIMIT_BYTES": "1KB"},
+			node:       RateLimit{Events: 50, Bytes: 1000},
+			namespaces: map[string]RateLimit{},
+		},
+		{
+			name: "file",
+			path: valid,
+			node: RateLimit{Events: 1000, Bytes: 5 << 20},
+			namespaces: map[string]RateLimit{
+				"batch": {Events: 100},
+				"quiet": {Bytes: 1024},
+			},
+		},
+		{
+			// 环境变量优先于文件
+			name: "env overrides file",
+			path: valid,
+			env:  map[string]string{"WATCHLOG_RATE_LIMIT": "10/s"},
+			node: RateLimit{Events: 10, Bytes: 5 << 20},
+			namespaces: map[string]RateLimit{
+				"batch": {Events: 100},
+				"quiet": {Bytes: 1024},
+			},
+		},
+		{name: "invalid env", env: map[string]string{"WATCHLOG_RATE_LIMIT": "10/d"}, err: true},
+		{name: "invalid env bytes", env: map[string]string{"WATCHLOG_RATE_LIMIT_BYTES": "fast"}, err: true},
+		{name: "invalid namespace", path: write("bad-ns.yaml", "namespaces:\n  batch:\n    events: lots\n"), err: true},
+		{name: "invalid default", path: write("bad-default.yaml", "default:\n  bytes: 1MiB/m\n"), err: true},
+		{name: "missing file", path: filepath.Join(dir, "missing.yaml"), err: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Setenv("WATCHLOG_RATE_LIMIT", c.env["WATCHLOG_RATE_LIMIT"])
+			t.Setenv("WATCHLOG_RATE_LIMIT_BYTES", c.env["WATCHLOG_RATE_LIMIT_BYTES"])
+			logRateLimits.node = RateLimit{Events: -1}
+			err := LoadRateLimits(c.path)
+			if (err != nil) != c.err {
+				t.Fatalf("LoadRateLimits err = %v, want error %v", err, c.err)
+			}
+			if c.err {
+				// 加载失败时保留原来的限速
+				if logRateLimits.node.Events != -1 {
+					t.Errorf("failed load replaced node rate limit with %+v", logRateLimits.node)
+				}
+				return
+			}
+			if logRateLimits.node != c.node {
+				t.Errorf("node rate limit = %+v, want %+v", logRateLimits.node, c.node)
+			}
+			if !reflect.DeepEqual(logRateLimits.namespaces, c.namespaces) {
+				t.Errorf("namespace rate limits = %+v, want %+v", logRateLimits.namespaces, c.namespaces)
+			}
+		})
+	}
+}
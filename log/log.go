@@ -2,16 +2,21 @@ package log
 
 import (
 	"context"
-	"github.com/docker/docker/api/types/filters"
+	"fmt"
 	"github.com/zeromicro/go-zero/core/logc"
 	"io/ioutil"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"text/template"
 	"watchlog/controller"
+	"watchlog/pkg/api"
+	"watchlog/pkg/bootstrap"
 	"watchlog/pkg/ctx"
+	"watchlog/pkg/pipeline"
 	"watchlog/pkg/provider"
+	"watchlog/pkg/tools"
 )
 
 // Run starts the log pilot.
@@ -21,8 +26,35 @@ func Run(tmplPath, logPrefix, baseDir string) error {
 		return err
 	}
 
-	p := provider.NewFilebeatPointer(tmpl, baseDir)
-	c := ctx.NewContext(baseDir, logPrefix, p)
+	specs, err := bootstrap.Outputs()
+	if err != nil {
+		return err
+	}
+
+	// Filebeat 类型的输出各自运行一个采集器, 原生类型的输出由原生采集器发送
+	pointers := make(map[string]*provider.FilebeatPointer)
+	router := pipeline.NewRouter()
+	for _, spec := range specs {
+		if !spec.Native() {
+			pointers[spec.Name] = provider.NewFilebeatPointer(tmpl, baseDir, spec.Name)
+			continue
+		}
+		env := tools.NewEnv(spec.Prefix)
+		batch := pipeline.LoadBatch(env)
+		sink, err := pipeline.NewSink(spec.Type, spec.Name, env)
+		if err != nil {
+			return fmt.Errorf("output %s: %s", spec.Name, err.Error())
+		}
+		if batch.SpoolDir == "" {
+			batch.SpoolDir = filepath.Join(provider.WatchlogDataDir, "spool", spec.Name)
+		}
+		if err := router.Add(spec.Name, sink, batch); err != nil {
+			return err
+		}
+	}
+
+	t := pipeline.NewTailer(router, filepath.Join(provider.WatchlogDataDir, "registry.json"))
+	c := ctx.NewContext(baseDir, logPrefix, pointers, t)
 	return startWorker(c)
 }
 
@@ -32,44 +64,55 @@ func loadTemplate(path string) (*template.Template, error) {
 	if err != nil {
 		return nil, err
 	}
-	return template.New("watchalert").Parse(string(data))
+	return template.New("watchalert").Funcs(tools.TemplateFuncs).Parse(string(data))
 }
 
 // startWorker initiates the worker process.
 func startWorker(c *ctx.Context) error {
-	if err := c.FilebeatPointer.CleanConfigs(); err != nil {
+	for _, p := range c.Pointers() {
+		// 保留上次的配置, 启动后同步容器时只改写变化的配置
+		if err := p.LoadIndex(); err != nil {
+			return err
+		}
+
+		if err := p.Start(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Tailer.Start(); err != nil {
 		return err
 	}
 
-	if err := c.FilebeatPointer.Start(); err != nil {
+	// 暂停采集的范围在同步容器前加载, 重启后保持暂停
+	if err := c.LoadPauses(filepath.Join(provider.WatchlogDataDir, "paused.json")); err != nil {
 		return err
 	}
 
-	if err := processContainers(c); err != nil {
+	rt := controller.NewRuntime(c)
+	server := api.NewServer(c, rt)
+	server.Start()
+
+	if err := processContainers(c, rt); err != nil {
 		return err
 	}
 
 	waitForShutdown()
+	server.Stop()
+	c.Tailer.Stop()
 	logc.Infof(context.Background(), "Program Stop Successful!!!")
 	return nil
 }
 
 // processContainers handles container processing based on the runtime type.
-func processContainers(c *ctx.Context) error {
-	switch os.Getenv("RUNTIME_TYPE") {
-	case "docker":
-		logc.Infof(context.Background(), "Processing Docker runtime")
-		filter := filters.NewArgs()
-		filter.Add("type", "container")
-		dockerController := controller.NewDockerInterface(c, filter)
-		return dockerController.ProcessContainers()
-	case "containerd":
-		logc.Infof(context.Background(), "Processing container runtime")
-		containerController := controller.NewContainerInterface(c)
-		return containerController.ProcessContainers()
-	default:
+func processContainers(c *ctx.Context, rt controller.InterRuntime) error {
+	if rt == nil {
+		// 未指定容器运行时, 没有需要同步的容器
+		c.SetSynced()
 		return nil
 	}
+	logc.Infof(context.Background(), "Processing %s runtime", c.Runtime)
+	return rt.ProcessContainers()
 }
 
 // waitForShutdown listens for OS signals to gracefully shut down the program.
@@ -2,13 +2,53 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"github.com/zeromicro/go-zero/core/logc"
+	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"time"
+	"watchlog/controller"
 	"watchlog/log"
+	logtypes "watchlog/log/config"
+	"watchlog/pkg/api"
+	"watchlog/pkg/bootstrap"
+	"watchlog/pkg/ctx"
+	"watchlog/pkg/provider"
+	"watchlog/pkg/tools"
 )
 
 func main() {
+	// Subcommand: generate collector base config only
+	if len(os.Args) > 1 && os.Args[1] == "bootstrap" {
+		if err := runBootstrap(os.Args[2:]); err != nil {
+			logc.Errorf(context.Background(), err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Subcommand: reprocess a container's logs from the beginning
+	if len(os.Args) > 1 && os.Args[1] == "reprocess" {
+		if err := runReprocess(os.Args[2:]); err != nil {
+			logc.Errorf(context.Background(), err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Subcommand: pause or resume collection of a container, pod or namespace
+	if len(os.Args) > 1 && (os.Args[1] == "pause" || os.Args[1] == "resume") {
+		if err := runPause(os.Args[1], os.Args[2:]); err != nil {
+			logc.Errorf(context.Background(), err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Command-line flags
 	template := flag.String("template", "", "Template filepath for fluentd or filebeat.")
 	flag.Parse()
@@ -29,12 +69,191 @@ func main() {
 		panic("template file cannot be empty")
 	}
 
+	// Load custom multiline presets
+	if path := os.Getenv("MULTILINE_PRESETS_FILE"); path != "" {
+		if err := tools.LoadMultilinePresets(path); err != nil {
+			logc.Errorf(context.Background(), err.Error())
+			return
+		}
+	}
+
+	// Load custom mask patterns and the masks applied to every log
+	if path := os.Getenv("MASK_PATTERNS_FILE"); path != "" {
+		if err := tools.LoadMaskPatterns(path); err != nil {
+			logc.Errorf(context.Background(), err.Error())
+			return
+		}
+	}
+	if err := logtypes.LoadGlobalMasks(os.Getenv("WATCHLOG_MASK")); err != nil {
+		logc.Errorf(context.Background(), "invalid WATCHLOG_MASK: %s", err.Error())
+		return
+	}
+
+	// Load node and namespace default rate limits
+	if err := logtypes.LoadRateLimits(os.Getenv("RATE_LIMITS_FILE")); err != nil {
+		logc.Errorf(context.Background(), err.Error())
+		return
+	}
+
+	// Generate collector base config
+	if err := bootstrap.Run(getLogPrefix()); err != nil {
+		logc.Errorf(context.Background(), err.Error())
+		return
+	}
+
 	// Run log processing
 	if err := log.Run(*template, getLogPrefix(), getBaseDir()); err != nil {
 		logc.Errorf(context.Background(), err.Error())
 	}
 }
 
+// runBootstrap generates the collector base config of an output from the output envs.
+func runBootstrap(args []string) error {
+	fs := flag.NewFlagSet("bootstrap", flag.ExitOnError)
+	output := fs.String("output", logtypes.DefaultOutput, "Output name declared in LOGGING_OUTPUTS.")
+	config := fs.String("config", "", "Collector base config filepath to write, defaults to the output's config file.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	specs, err := bootstrap.Outputs()
+	if err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		if spec.Name != *output {
+			continue
+		}
+		if spec.Native() {
+			return fmt.Errorf("output %s is served by the native pipeline", spec.Name)
+		}
+		f := provider.NewFilebeatPointer(nil, "", spec.Name)
+		if *config == "" {
+			*config = f.GetConfFile()
+		}
+		return bootstrap.Generate(getLogPrefix(), spec, f.GetConfHome(), *config)
+	}
+	return fmt.Errorf("output %s is not declared in LOGGING_OUTPUTS", *output)
+}
+
+// runReprocess asks the admin api for the reprocess plan of a container and runs it once the byte count is confirmed.
+func runReprocess(args []string) error {
+	fs := flag.NewFlagSet("reprocess", flag.ExitOnError)
+	container := fs.String("container", "", "Container id or unique id prefix to reprocess.")
+	admin := fs.String("admin", getAdminAddr(), "Address of the WatchLog admin api.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *container == "" {
+		return fmt.Errorf("container cannot be empty")
+	}
+
+	base := "http://" + *admin + "/containers/" + url.PathEscape(*container) + "/reprocess"
+	var plan controller.ReprocessPlan
+	if err := adminRequest(http.MethodGet, base, &plan); err != nil {
+		return err
+	}
+	for _, f := range plan.Files {
+		fmt.Printf("%-10s %-10s %12d  %s\n", f.Type, f.Output, f.Size, f.Path)
+	}
+	fmt.Printf("Container %s: %d files, %d bytes will be read again.\n", plan.Container, len(plan.Files), plan.Bytes)
+	fmt.Printf("Type the byte count to confirm: ")
+
+	var confirm string
+	if _, err := fmt.Scanln(&confirm); err != nil {
+		return fmt.Errorf("read confirmation failed, err: %s", err.Error())
+	}
+	if confirm != strconv.FormatInt(plan.Bytes, 10) {
+		return fmt.Errorf("confirmation %q does not match %d bytes, aborted", confirm, plan.Bytes)
+	}
+	if err := adminRequest(http.MethodPost, base+"?confirm="+confirm, &plan); err != nil {
+		return err
+	}
+	fmt.Printf("Reprocessing %d bytes of container %s.\n", plan.Bytes, plan.Container)
+	return nil
+}
+
+// runPause pauses or resumes collection through the admin api, pause without a scope lists the paused scopes.
+func runPause(command string, args []string) error {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	container := fs.String("container", "", "Container id or unique id prefix.")
+	pod := fs.String("pod", "", "Pod as {namespace}/{pod}.")
+	namespace := fs.String("namespace", "", "Namespace.")
+	admin := fs.String("admin", getAdminAddr(), "Address of the WatchLog admin api.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	for kind, value := range map[string]string{"container": *container, "pod": *pod, "namespace": *namespace} {
+		if value == "" {
+			continue
+		}
+		if query.Get("kind") != "" {
+			return fmt.Errorf("only one of -container, -pod and -namespace can be set")
+		}
+		query.Set("kind", kind)
+		query.Set("value", value)
+	}
+
+	base := "http://" + *admin + "/pauses"
+	var result struct {
+		Pauses     []ctx.Pause `json:"pauses"`
+		Containers []string    `json:"containers"`
+	}
+	switch {
+	case query.Get("kind") == "" && command == "pause":
+		if err := adminRequest(http.MethodGet, base, &result.Pauses); err != nil {
+			return err
+		}
+	case query.Get("kind") == "":
+		return fmt.Errorf("one of -container, -pod and -namespace is required")
+	case command == "pause":
+		if err := adminRequest(http.MethodPost, base+"?"+query.Encode(), &result); err != nil {
+			return err
+		}
+		fmt.Printf("Paused %d containers.\n", len(result.Containers))
+	default:
+		if err := adminRequest(http.MethodDelete, base+"?"+query.Encode(), &result); err != nil {
+			return err
+		}
+		fmt.Printf("Resumed %d containers.\n", len(result.Containers))
+	}
+	for _, p := range result.Pauses {
+		fmt.Printf("%-10s %-40s paused since %s\n", p.Kind, p.Value, p.Since.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// adminRequest sends a request to the admin api and decodes the json response into v.
+func adminRequest(method, target string, v interface{}) error {
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("%s %s failed, status: %s, err: %s", method, target, resp.Status, e.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// getAdminAddr retrieves the admin api address from the environment or defaults to api.DefaultAdminAddr.
+func getAdminAddr() string {
+	if addr := os.Getenv("WATCHLOG_ADMIN_ADDR"); len(addr) > 0 {
+		return addr
+	}
+	return api.DefaultAdminAddr
+}
+
 // setDefaultDockerAPIVersion sets the default Docker API version if not already set.
 func setDefaultDockerAPIVersion() error {
 	if os.Getenv("DOCKER_API_VERSION") == "" {
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"watchlog/controller"
+	"watchlog/pkg/ctx"
+	"watchlog/pkg/pipeline"
+)
+
+// containerDetail 容器的采集配置及采集进度
+type containerDetail struct {
+	ctx.Container
+	// Rendered 渲染的采集配置, 键为输出名称
+	Rendered map[string]string `json:"rendered,omitempty"`
+	// Files 日志文件的采集进度
+	Files []pipeline.FileLag `json:"files"`
+}
+
+// reprocessTTL 重新采集计划的有效期, 过期后需要重新确认
+const reprocessTTL = 10 * time.Minute
+
+// reprocessPlan 等待确认的重新采集计划
+type reprocessPlan struct {
+	controller.ReprocessPlan
+	expires time.Time
+}
+
+// pauseResult 暂停或恢复后的暂停范围及受影响的容器
+type pauseResult struct {
+	Pauses     []ctx.Pause `json:"pauses"`
+	Containers []string    `json:"containers"`
+}
+
+// containers 返回 WatchLog 管理的全部容器
+func (s *Server) containers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.c.Containers())
+}
+
+// container 处理 /containers/{id} 及 /containers/{id}/{action}
+func (s *Server) container(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/containers/"), "/")
+	if id == "" {
+		writeError(w, http.StatusNotFound, "container id is required")
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		c, err := s.c.GetContainer(id)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, s.detail(c))
+	case action == "resync" && r.Method == http.MethodPost:
+		s.resync(w, id)
+	case action == "reprocess" && r.Method == http.MethodGet:
+		s.planReprocess(w, id)
+	case action == "reprocess" && r.Method == http.MethodPost:
+		s.reprocess(w, id, r.URL.Query().Get("confirm"))
+	case action == "" || action == "resync" || action == "reprocess":
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		writeError(w, http.StatusNotFound, "unknown action "+action)
+	}
+}
+
+// resync 重新读取容器信息并生成采集配置, 未记录的容器同样可以生成
+func (s *Server) resync(w http.ResponseWriter, id string) {
+	if s.rt == nil {
+		writeError(w, http.StatusServiceUnavailable, "container runtime is not configured")
+		return
+	}
+	if c, err := s.c.GetContainer(id); err == nil {
+		id = c.ID
+	}
+	if err := s.rt.Resync(id); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c, err := s.c.GetContainer(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "container "+id+" has no log config")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.detail(c))
+}
+
+// planReprocess 返回重新采集将读取的文件及字节数, 计划在有效期内等待确认
+func (s *Server) planReprocess(w http.ResponseWriter, id string) {
+	plan, err := controller.PlanReprocess(s.c, id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	s.mu.Lock()
+	s.plans[plan.Container] = reprocessPlan{ReprocessPlan: plan, expires: time.Now().Add(reprocessTTL)}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// reprocess 确认的字节数与计划一致时从头重新采集容器日志
+func (s *Server) reprocess(w http.ResponseWriter, id, confirm string) {
+	if c, err := s.c.GetContainer(id); err == nil {
+		id = c.ID
+	}
+	bytes, err := strconv.ParseInt(confirm, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "confirm the byte count of the reprocess plan with ?confirm=<bytes>")
+		return
+	}
+
+	s.mu.Lock()
+	plan, ok := s.plans[id]
+	if ok && time.Now().After(plan.expires) {
+		delete(s.plans, id)
+		ok = false
+	}
+	if ok && plan.Bytes == bytes {
+		delete(s.plans, id)
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusConflict, "no reprocess plan for container "+id+", get the plan first")
+		return
+	}
+	if plan.Bytes != bytes {
+		writeError(w, http.StatusConflict, fmt.Sprintf("confirmed %d bytes, but the plan reprocesses %d bytes", bytes, plan.Bytes))
+		return
+	}
+
+	ret, err := controller.Reprocess(s.c, s.rt, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+// pauses 查询, 暂停或恢复容器, Pod 及命名空间的采集, 范围由 kind 及 value 参数指定
+func (s *Server) pauses(w http.ResponseWriter, r *http.Request) {
+	kind, value := r.URL.Query().Get("kind"), r.URL.Query().Get("value")
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.c.Pauses())
+	case http.MethodPost:
+		containers, err := controller.Pause(s.c, kind, value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, pauseResult{Pauses: s.c.Pauses(), Containers: containers})
+	case http.MethodDelete:
+		containers, err := controller.Resume(s.c, s.rt, kind, value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, pauseResult{Pauses: s.c.Pauses(), Containers: containers})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) detail(c ctx.Container) containerDetail {
+	d := containerDetail{Container: c, Rendered: make(map[string]string), Files: make([]pipeline.FileLag, 0)}
+	for output, path := range c.ConfigPaths {
+		if data, err := ioutil.ReadFile(path); err == nil {
+			d.Rendered[output] = string(data)
+		}
+	}
+	for _, f := range controller.FileLag(s.c) {
+		if f.Container == c.ID {
+			d.Files = append(d.Files, f)
+		}
+	}
+	return d
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logc"
+)
+
+const (
+	// defaultStreamTimeout 事件流断开超过该时间后存活检查失败, 可通过 WATCHLOG_EVENT_STREAM_TIMEOUT 修改
+	defaultStreamTimeout = 5 * time.Minute
+	// 采集器在 crashWindow 内退出 crashExits 次视为频繁重启
+	crashWindow = 10 * time.Minute
+	crashExits  = 5
+)
+
+// check 单项检查的结果
+type check struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+type healthResult struct {
+	Status string  `json:"status"`
+	Checks []check `json:"checks"`
+}
+
+func streamTimeout() time.Duration {
+	v := os.Getenv("WATCHLOG_EVENT_STREAM_TIMEOUT")
+	if v == "" {
+		return defaultStreamTimeout
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		logc.Errorf(context.Background(), "invalid WATCHLOG_EVENT_STREAM_TIMEOUT %q, use %s", v, defaultStreamTimeout)
+		return defaultStreamTimeout
+	}
+	return d
+}
+
+// healthz 存活检查, 采集器频繁重启或容器运行时事件流断开超过阈值时失败
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	var checks []check
+	for _, p := range s.c.Pointers() {
+		c := check{Name: "collector " + p.Output, OK: true}
+		if n := p.Exits(time.Now().Add(-crashWindow)); n >= crashExits {
+			c.OK = false
+			c.Message = fmt.Sprintf("exited %d times in %s", n, crashWindow)
+		}
+		checks = append(checks, c)
+	}
+
+	if s.c.Runtime != "" {
+		c := check{Name: "event stream", OK: true}
+		if since := s.c.StreamDownSince(); !since.IsZero() {
+			c.Message = fmt.Sprintf("down since %s", since.Format(time.RFC3339))
+			c.OK = time.Since(since) < s.streamTimeout
+		}
+		checks = append(checks, c)
+	}
+	writeHealth(w, checks)
+}
+
+// readyz 就绪检查, 启动时的容器同步完成前失败
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	c := check{Name: "initial sync", OK: s.c.Synced()}
+	if !c.OK {
+		c.Message = "containers are being synced"
+	}
+	writeHealth(w, []check{c})
+}
+
+func writeHealth(w http.ResponseWriter, checks []check) {
+	result := healthResult{Status: "ok", Checks: checks}
+	code := http.StatusOK
+	for _, c := range checks {
+		if !c.OK {
+			result.Status = "fail"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, code, result)
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"watchlog/controller"
+)
+
+var (
+	containerLagDesc = prometheus.NewDesc("watchlog_container_lag_bytes",
+		"Bytes of container log files not yet read by the collector.",
+		[]string{"runtime", "namespace", "container", "output"}, nil)
+	containerLastReadDesc = prometheus.NewDesc("watchlog_container_last_read_timestamp_seconds",
+		"Last time the collector advanced the offset of the container log files.",
+		[]string{"runtime", "namespace", "container", "output"}, nil)
+	collectorUpDesc = prometheus.NewDesc("watchlog_collector_up",
+		"Whether the collector process is running.",
+		[]string{"output"}, nil)
+	collectorUptimeDesc = prometheus.NewDesc("watchlog_collector_uptime_seconds",
+		"Seconds since the collector process was last started.",
+		[]string{"output"}, nil)
+	collectorFilteredDesc = prometheus.NewDesc("watchlog_collector_filtered_events_total",
+		"Events dropped by the collector processors, such as sampling, since the collector was last started.",
+		[]string{"output"}, nil)
+)
+
+// collector 抓取时计算的指标, 采集延迟读取采集器仓库
+type collector struct {
+	s *Server
+}
+
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- containerLagDesc
+	ch <- containerLastReadDesc
+	ch <- collectorUpDesc
+	ch <- collectorUptimeDesc
+	ch <- collectorFilteredDesc
+}
+
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	for _, p := range c.s.c.Pointers() {
+		running, started, _ := p.Status()
+		var up, uptime float64
+		if running {
+			up = 1
+			uptime = time.Since(started).Seconds()
+		}
+		ch <- prometheus.MustNewConstMetric(collectorUpDesc, prometheus.GaugeValue, up, p.Output)
+		ch <- prometheus.MustNewConstMetric(collectorUptimeDesc, prometheus.GaugeValue, uptime, p.Output)
+		if !running {
+			continue
+		}
+		// 采集器的采样不区分容器, 按输出统计
+		if filtered, err := p.FilteredEvents(); err == nil {
+			ch <- prometheus.MustNewConstMetric(collectorFilteredDesc, prometheus.CounterValue, float64(filtered), p.Output)
+		}
+	}
+
+	// 按容器及输出汇总
+	type key struct{ namespace, container, output string }
+	lags := make(map[key]int64)
+	lastRead := make(map[key]time.Time)
+	for _, f := range controller.FileLag(c.s.c) {
+		k := key{f.Namespace, f.Container, f.Output}
+		lags[k] += f.Lag
+		if f.LastRead != nil && f.LastRead.After(lastRead[k]) {
+			lastRead[k] = *f.LastRead
+		}
+	}
+	runtime := c.s.c.Runtime
+	for k, lag := range lags {
+		ch <- prometheus.MustNewConstMetric(containerLagDesc, prometheus.GaugeValue, float64(lag), runtime, k.namespace, k.container, k.output)
+		if t, ok := lastRead[k]; ok {
+			ch <- prometheus.MustNewConstMetric(containerLastReadDesc, prometheus.GaugeValue, float64(t.Unix()), runtime, k.namespace, k.container, k.output)
+		}
+	}
+}
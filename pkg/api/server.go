@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/zeromicro/go-zero/core/logc"
+	"watchlog/controller"
+	"watchlog/pkg/ctx"
+	"watchlog/pkg/metrics"
+	"watchlog/pkg/pipeline"
+	"watchlog/pkg/provider"
+)
+
+const (
+	// DefaultAddr WatchLog 状态接口的默认监听地址, 可通过 WATCHLOG_LISTEN_ADDR 修改
+	DefaultAddr = ":8686"
+	// DefaultAdminAddr 管理接口的默认监听地址, 只监听本地, 可通过 WATCHLOG_ADMIN_ADDR 修改
+	DefaultAdminAddr = "127.0.0.1:8687"
+)
+
+// Server WatchLog 状态接口及管理接口
+type Server struct {
+	c             *ctx.Context
+	rt            controller.InterRuntime
+	srv           *http.Server
+	admin         *http.Server
+	streamTimeout time.Duration
+
+	mu sync.Mutex
+	// plans 等待确认的重新采集计划, 键为容器 ID
+	plans map[string]reprocessPlan
+}
+
+// NewServer creates the status and admin server, rt is nil when no container runtime is configured
+func NewServer(c *ctx.Context, rt controller.InterRuntime) *Server {
+	addr := os.Getenv("WATCHLOG_LISTEN_ADDR")
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	adminAddr := os.Getenv("WATCHLOG_ADMIN_ADDR")
+	if adminAddr == "" {
+		adminAddr = DefaultAdminAddr
+	}
+
+	s := &Server{c: c, rt: rt, streamTimeout: streamTimeout(), plans: make(map[string]reprocessPlan)}
+	if err := metrics.Registry.Register(collector{s: s}); err != nil {
+		logc.Errorf(context.Background(), "register metrics failed, err: %s", err.Error())
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
+	mux.HandleFunc("/api/v1/buffers", s.buffers)
+	mux.HandleFunc("/api/v1/configs", s.configs)
+	mux.HandleFunc("/api/v1/removals", s.removals)
+	mux.HandleFunc("/api/v1/lag", s.lag)
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+	s.srv = &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("/containers", s.containers)
+	adminMux.HandleFunc("/containers/", s.container)
+	adminMux.HandleFunc("/pauses", s.pauses)
+	s.admin = &http.Server{Addr: adminAddr, Handler: adminMux, ReadHeaderTimeout: 10 * time.Second}
+	return s
+}
+
+// Start 后台监听, 监听失败只记录日志, 不影响采集
+func (s *Server) Start() {
+	for _, srv := range []*http.Server{s.srv, s.admin} {
+		go func(srv *http.Server) {
+			logc.Infof(context.Background(), "Starting api server on %s", srv.Addr)
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logc.Errorf(context.Background(), "api server on %s failed, err: %s", srv.Addr, err.Error())
+			}
+		}(srv)
+	}
+}
+
+func (s *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = s.srv.Shutdown(ctx)
+	_ = s.admin.Shutdown(ctx)
+}
+
+// buffers 返回每个输出暂存的字节数及最早暂存的时间
+func (s *Server) buffers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	stats := make([]pipeline.BufferStats, 0)
+	for _, p := range s.c.Pointers() {
+		size, segments, oldest, err := p.QueueStats()
+		if err != nil {
+			logc.Errorf(context.Background(), "read disk queue of output %s failed, err: %s", p.Output, err.Error())
+			continue
+		}
+		b := pipeline.BufferStats{
+			Output:  p.Output,
+			Type:    "filebeat",
+			Path:    p.GetQueuePath(),
+			Bytes:   size,
+			Batches: segments,
+		}
+		if !oldest.IsZero() {
+			b.Oldest = &oldest
+			b.AgeSeconds = time.Since(oldest).Seconds()
+		}
+		stats = append(stats, b)
+	}
+	stats = append(stats, s.c.Tailer.Buffers()...)
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// configs 返回每个容器采集配置的版本及最后一次实际变化的时间
+func (s *Server) configs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	generations := make([]provider.ConfigGeneration, 0)
+	for _, p := range s.c.Pointers() {
+		generations = append(generations, p.Generations()...)
+	}
+	writeJSON(w, http.StatusOK, generations)
+}
+
+// removals 返回容器退出后等待日志采集完成再删除的配置
+func (s *Server) removals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.c.Removals())
+}
+
+// lag 返回每个容器日志文件未采集的字节数及最后一次采集的时间
+func (s *Server) lag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, pipeline.GroupLag(controller.FileLag(s.c)))
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
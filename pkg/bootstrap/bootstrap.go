@@ -0,0 +1,323 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/go-ucfg"
+	ucfgyaml "github.com/elastic/go-ucfg/yaml"
+	"github.com/zeromicro/go-zero/core/logc"
+	"gopkg.in/yaml.v2"
+	logtypes "watchlog/log/config"
+	"watchlog/pkg/pipeline"
+	"watchlog/pkg/provider"
+	"watchlog/pkg/tools"
+)
+
+// EsCredential 挂载的 elasticsearch 认证信息, 格式为 user:password, 仅用于默认输出且未设置 ELASTICSEARCH_USER / ELASTICSEARCH_PASSWORD 时
+const EsCredential = "/run/secrets/es_credential"
+
+// OutputSpec 输出声明
+type OutputSpec struct {
+	// Name 默认输出为 default, 命名输出为 LOGGING_OUTPUTS 中声明的名称
+	Name string
+	// Prefix 输出的环境变量前缀, 例如 audit 输出读取 AUDIT_LOGGING_OUTPUT, AUDIT_KAFKA_BROKERS
+	Prefix string
+	Type   string
+}
+
+// Native returns whether the output is served by the native pipeline
+func (o OutputSpec) Native() bool {
+	return pipeline.IsNative(o.Type)
+}
+
+// Outputs 返回默认输出及 LOGGING_OUTPUTS 声明的命名输出
+func Outputs() ([]OutputSpec, error) {
+	output := os.Getenv("LOGGING_OUTPUT")
+	if v := os.Getenv("FILEBEAT_OUTPUT"); v != "" {
+		output = v
+	}
+	specs := []OutputSpec{{Name: logtypes.DefaultOutput, Type: output}}
+
+	r := tools.NewEnv("")
+	for _, name := range r.List("LOGGING_OUTPUTS") {
+		if err := logtypes.ValidateOutputName(name); err != nil {
+			return nil, err
+		}
+		if name == logtypes.DefaultOutput {
+			return nil, fmt.Errorf("LOGGING_OUTPUTS can not declare %s output", name)
+		}
+
+		prefix := strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		spec := OutputSpec{Name: name, Prefix: prefix, Type: os.Getenv(prefix + "LOGGING_OUTPUT")}
+		if _, ok := filebeatOutputs[spec.Type]; !ok && !spec.Native() {
+			return nil, fmt.Errorf("%sLOGGING_OUTPUT %q of output %s is unsupported", prefix, spec.Type, name)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// filebeatOutputs 由 Filebeat 发送的输出类型
+var filebeatOutputs = map[string]bool{
+	"elasticsearch": true,
+	"logstash":      true,
+	"file":          true,
+	"redis":         true,
+	"kafka":         true,
+	"count":         true,
+	"console":       true,
+}
+
+// Run 为每个 Filebeat 类型的输出生成采集器基础配置
+func Run(logPrefix string) error {
+	specs, err := Outputs()
+	if err != nil {
+		return err
+	}
+
+	for _, spec := range specs {
+		if spec.Native() {
+			continue
+		}
+		f := provider.NewFilebeatPointer(nil, "", spec.Name)
+		if err := Generate(logPrefix, spec, f.GetConfHome(), f.GetConfFile()); err != nil {
+			return fmt.Errorf("output %s: %s", spec.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// Generate 生成输出的采集器基础配置并写入 path, inputsDir 为容器采集配置目录
+func Generate(logPrefix string, spec OutputSpec, inputsDir, path string) error {
+	c, err := Load(logPrefix, spec, inputsDir)
+	if err != nil {
+		return err
+	}
+
+	data, err := c.Render()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(inputsDir, 0755); err != nil {
+		return err
+	}
+
+	logc.Infof(context.Background(), "Write filebeat config, path: %s", path)
+	return ioutil.WriteFile(path, data, os.FileMode(0644))
+}
+
+// Load 根据输出类型及对应的环境变量构建配置
+func Load(logPrefix string, spec OutputSpec, inputsDir string) (*Config, error) {
+	c := &Config{
+		Processors: []map[string]interface{}{
+			{"add_cloud_metadata": nil},
+		},
+		FilebeatConfig: FilebeatConfig{
+			Modules: Reload{Path: provider.FilebeatBaseConf + "/modules.d/*.yml", Enabled: false},
+			Inputs:  Reload{Path: inputsDir + "/*.yml", Enabled: true},
+		},
+	}
+
+	f := provider.NewFilebeatPointer(nil, "", spec.Name)
+	c.HTTPEnabled = true
+	c.HTTPHost = "unix://" + f.GetStatsSocket()
+
+	r := tools.NewEnv(spec.Prefix)
+	if size := r.Size("QUEUE_DISK_MAX_SIZE"); size != "" {
+		c.Queue = &Queue{Disk: &DiskQueue{Path: f.GetQueuePath(), MaxSize: size}}
+	}
+
+	switch spec.Type {
+	case "elasticsearch":
+		c.Elasticsearch = loadElasticsearch(r, logPrefix)
+		disabled := false
+		c.Setup = Setup{
+			ILMEnabled:      &disabled,
+			TemplateName:    logPrefix,
+			TemplatePattern: logPrefix + "-*",
+		}
+	case "logstash":
+		c.Logstash = loadLogstash(r)
+	case "file":
+		c.File = loadFile(r)
+	case "redis":
+		c.Redis = loadRedis(r, logPrefix)
+	case "kafka":
+		c.Kafka = loadKafka(r, logPrefix)
+	case "count":
+		c.Count = &Count{}
+	default:
+		logc.Infof(context.Background(), "use default output")
+		c.Console = &Console{}
+		if pretty := r.BoolPtr("CONSOLE_PRETTY"); pretty != nil {
+			c.Console.Pretty = *pretty
+		}
+	}
+
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Render 生成 yaml 并校验采集器能够解析
+func (c *Config) Render() ([]byte, error) {
+	if err := c.Output.Validate(); err != nil {
+		return nil, err
+	}
+
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("marshal filebeat config failed, err: %s", err.Error())
+	}
+
+	parsed, err := ucfgyaml.NewConfig(data, ucfg.PathSep("."))
+	if err != nil {
+		return nil, fmt.Errorf("generated filebeat config is invalid, err: %s", err.Error())
+	}
+	output, err := parsed.Child("output", -1)
+	if err != nil {
+		return nil, fmt.Errorf("generated filebeat config has no output, err: %s", err.Error())
+	}
+	if fields := output.GetFields(); len(fields) != 1 {
+		return nil, fmt.Errorf("generated filebeat config must have exactly one output, got %v", fields)
+	}
+
+	return data, nil
+}
+
+func topicRoute() Condition {
+	return Condition{HasFields: []string{"topic"}}
+}
+
+func loadElasticsearch(r *tools.Env, logPrefix string) *Elasticsearch {
+	es := &Elasticsearch{
+		Hosts:       r.List("ELASTICSEARCH_HOSTS"),
+		Protocol:    r.Str("ELASTICSEARCH_SCHEME"),
+		Username:    r.Str("ELASTICSEARCH_USER"),
+		Password:    r.Str("ELASTICSEARCH_PASSWORD"),
+		Worker:      r.Int("ELASTICSEARCH_WORKER"),
+		Path:        r.Str("ELASTICSEARCH_PATH"),
+		BulkMaxSize: r.Int("ELASTICSEARCH_BULK_MAX_SIZE"),
+		Index:       logPrefix + "-%{+yyyy.MM.dd}",
+		SSL:         loadSSL(r, "ELASTICSEARCH"),
+	}
+	if len(es.Hosts) == 0 {
+		es.Hosts = r.HostPort("ELASTICSEARCH_HOST", "ELASTICSEARCH_PORT")
+	}
+	if es.SSL != nil && es.SSL.Enabled && es.Protocol == "" {
+		es.Protocol = "https"
+	}
+
+	// 兼容旧的挂载方式, 环境变量优先, 命名输出不读取默认输出的认证信息
+	if r.Prefix == "" && es.Username == "" && es.Password == "" {
+		if data, err := ioutil.ReadFile(EsCredential); err == nil {
+			user, password, _ := strings.Cut(strings.TrimSpace(string(data)), ":")
+			es.Username, es.Password = user, password
+		}
+	}
+
+	// 按日志的 topic 字段路由索引, 可通过 LOG_INDEX_TEMPLATE 自定义, 例如按命名空间区分租户
+	index := r.Str("LOG_INDEX_TEMPLATE")
+	if index == "" {
+		index = logPrefix + "-%{[topic]}-%{+yyyy.MM.dd}"
+	}
+	es.Indices = []IndexRoute{{Index: index, When: topicRoute()}}
+	return es
+}
+
+func loadLogstash(r *tools.Env) *Logstash {
+	index := r.Str("FILEBEAT_INDEX")
+	if index == "" {
+		index = "filebeat"
+	}
+	return &Logstash{
+		Hosts:       r.HostPort("LOGSTASH_HOST", "LOGSTASH_PORT"),
+		Index:       index + "-%{+yyyy.MM.dd}",
+		Worker:      r.Int("LOGSTASH_WORKER"),
+		LoadBalance: r.BoolPtr("LOGSTASH_LOADBALANCE"),
+		BulkMaxSize: r.Int("LOGSTASH_BULK_MAX_SIZE"),
+		SlowStart:   r.BoolPtr("LOGSTASH_SLOW_START"),
+	}
+}
+
+func loadFile(r *tools.Env) *File {
+	f := &File{
+		Path:          r.Required("FILE_PATH"),
+		Filename:      r.Str("FILE_NAME"),
+		RotateEveryKB: r.Int("FILE_ROTATE_SIZE"),
+		NumberOfFiles: r.Int("FILE_NUMBER_OF_FILES"),
+	}
+	if v := r.Str("FILE_PERMISSIONS"); v != "" {
+		perm, err := strconv.ParseUint(v, 8, 32)
+		if err != nil {
+			r.Errorf("%sFILE_PERMISSIONS must be an octal mode, e.g. 0600, got %q", r.Prefix, v)
+		}
+		f.Permissions = uint32(perm)
+	}
+	return f
+}
+
+func loadRedis(r *tools.Env, logPrefix string) *Redis {
+	return &Redis{
+		Hosts:       r.HostPort("REDIS_HOST", "REDIS_PORT"),
+		Key:         logPrefix,
+		Keys:        []KeyRoute{{Key: "%{[topic]}", When: topicRoute()}},
+		Worker:      r.Int("REDIS_WORKER"),
+		Password:    r.Str("REDIS_PASSWORD"),
+		DataType:    r.Str("REDIS_DATATYPE"),
+		LoadBalance: r.BoolPtr("REDIS_LOADBALANCE"),
+		Timeout:     r.Duration("REDIS_TIMEOUT"),
+		BulkMaxSize: r.Int("REDIS_BULK_MAX_SIZE"),
+		SSL:         loadSSL(r, "REDIS"),
+	}
+}
+
+func loadKafka(r *tools.Env, logPrefix string) *Kafka {
+	k := &Kafka{
+		Hosts:             r.List("KAFKA_BROKERS"),
+		Topic:             logPrefix,
+		Topics:            []TopicRoute{{Topic: "%{[topic]}", When: topicRoute()}},
+		Version:           r.Str("KAFKA_VERSION"),
+		Username:          r.Str("KAFKA_USERNAME"),
+		Password:          r.Str("KAFKA_PASSWORD"),
+		Worker:            r.Int("KAFKA_WORKER"),
+		Key:               r.Str("KAFKA_PARTITION_KEY"),
+		ClientID:          r.Str("KAFKA_CLIENT_ID"),
+		BulkMaxSize:       r.Int("KAFKA_BULK_MAX_SIZE"),
+		BrokerTimeout:     r.Duration("KAFKA_BROKER_TIMEOUT"),
+		ChannelBufferSize: r.Int("KAFKA_CHANNEL_BUFFER_SIZE"),
+		KeepAlive:         r.Duration("KAFKA_KEEP_ALIVE"),
+		MaxMessageBytes:   r.Int("KAFKA_MAX_MESSAGE_BYTES"),
+		SSL:               loadSSL(r, "KAFKA"),
+	}
+	if len(k.Hosts) == 0 {
+		r.Errorf("%sKAFKA_BROKERS required", r.Prefix)
+	}
+	k.SASL = loadSASL(r, k)
+
+	// -1 等待所有副本确认, 0 不等待, 1 等待 leader 确认
+	if acks := r.Str("KAFKA_REQUIRE_ACKS"); acks != "" {
+		i, err := strconv.Atoi(acks)
+		if err != nil || i < -1 || i > 1 {
+			r.Errorf("%sKAFKA_REQUIRE_ACKS must be -1, 0 or 1, got %q", r.Prefix, acks)
+		}
+		k.RequiredAcks = &i
+	}
+
+	// random, round_robin, hash
+	switch partition := r.Str("KAFKA_PARTITION"); partition {
+	case "":
+	case "random", "round_robin", "hash":
+		k.Partition = map[string]map[string]interface{}{partition: {}}
+	default:
+		r.Errorf("%sKAFKA_PARTITION must be random, round_robin or hash, got %q", r.Prefix, partition)
+	}
+	return k
+}
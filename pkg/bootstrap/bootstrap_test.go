@@ -0,0 +1,305 @@
+package bootstrap
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-ucfg"
+	ucfgyaml "github.com/elastic/go-ucfg/yaml"
+	logtypes "watchlog/log/config"
+)
+
+// password 含有 yaml 及 ucfg 中有特殊含义的字符
+const password = `a:b#"c`
+
+// setEnvs 设置带 prefix 的环境变量
+func setEnvs(t *testing.T, prefix string, envs map[string]string) {
+	t.Helper()
+	for k, v := range envs {
+		t.Setenv(prefix+k, v)
+	}
+}
+
+// render 生成配置并按采集器的方式重新解析
+func render(t *testing.T, spec OutputSpec) (*Config, *ucfg.Config) {
+	t.Helper()
+	c, err := Load("watchlog", spec, "/usr/share/filebeat/inputs.d")
+	if err != nil {
+		t.Fatalf("Load %s failed, err: %s", spec.Type, err.Error())
+	}
+	data, err := c.Render()
+	if err != nil {
+		t.Fatalf("Render %s failed, err: %s", spec.Type, err.Error())
+	}
+	parsed, err := ucfgyaml.NewConfig(data, ucfg.PathSep("."))
+	if err != nil {
+		t.Fatalf("parse rendered %s config failed, err: %s\n%s", spec.Type, err.Error(), data)
+	}
+	return c, parsed
+}
+
+func unpack(t *testing.T, c *ucfg.Config, path string) map[string]interface{} {
+	t.Helper()
+	child, err := c.Child(path, -1, ucfg.PathSep("."))
+	if err != nil {
+		t.Fatalf("%s not found, err: %s", path, err.Error())
+	}
+	ret := make(map[string]interface{})
+	if err := child.Unpack(&ret); err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func TestRender(t *testing.T) {
+	cases := []struct {
+		typ  string
+		envs map[string]string
+		// want 解析后输出中的字段
+		want map[string]interface{}
+	}{
+		{
+			typ: "elasticsearch",
+			envs: map[string]string{
+				"ELASTICSEARCH_HOSTS":    "es-1:9200,es-2:9200",
+				"ELASTICSEARCH_USER":     "elastic",
+				"ELASTICSEARCH_PASSWORD": password,
+			},
+			want: map[string]interface{}{
+				"hosts":    []interface{}{"es-1:9200", "es-2:9200"},
+				"username": "elastic",
+				"password": password,
+				"index":    "watchlog-%{+yyyy.MM.dd}",
+			},
+		},
+		{
+			typ:  "logstash",
+			envs: map[string]string{"LOGSTASH_HOST": "logstash", "LOGSTASH_PORT": "5044", "LOGSTASH_LOADBALANCE": "false"},
+			want: map[string]interface{}{"hosts": []interface{}{"logstash:5044"}, "loadbalance": false},
+		},
+		{
+			typ:  "file",
+			envs: map[string]string{"FILE_PATH": "/tmp/watchlog", "FILE_PERMISSIONS": "0600"},
+			want: map[string]interface{}{"path": "/tmp/watchlog", "permissions": uint64(0600)},
+		},
+		{
+			typ:  "redis",
+			envs: map[string]string{"REDIS_HOST": "redis", "REDIS_PORT": "6379", "REDIS_PASSWORD": password, "REDIS_DATATYPE": "list"},
+			want: map[string]interface{}{"hosts": []interface{}{"redis:6379"}, "password": password, "key": "watchlog", "datatype": "list"},
+		},
+		{
+			typ: "kafka",
+			envs: map[string]string{
+				"KAFKA_BROKERS":        "kafka-1:9092,kafka-2:9092",
+				"KAFKA_USERNAME":       "watchlog",
+				"KAFKA_PASSWORD":       password,
+				"KAFKA_SASL_MECHANISM": "SCRAM-SHA-512",
+				"KAFKA_REQUIRE_ACKS":   "-1",
+				"KAFKA_PARTITION":      "round_robin",
+			},
+			want: map[string]interface{}{
+				"hosts":         []interface{}{"kafka-1:9092", "kafka-2:9092"},
+				"topic":         "watchlog",
+				"password":      password,
+				"required_acks": int64(-1),
+				"sasl":          map[string]interface{}{"mechanism": "SCRAM-SHA-512"},
+				"partition":     map[string]interface{}{"round_robin": nil},
+			},
+		},
+		{typ: "count", want: map[string]interface{}{}},
+		{typ: "console", envs: map[string]string{"CONSOLE_PRETTY": "true"}, want: map[string]interface{}{"pretty": true}},
+		// 未知类型使用 console
+		{typ: "", want: map[string]interface{}{"pretty": false}},
+	}
+	for _, c := range cases {
+		t.Run(c.typ, func(t *testing.T) {
+			prefix := "RENDER_" + strings.ToUpper(c.typ) + "_"
+			setEnvs(t, prefix, c.envs)
+			_, parsed := render(t, OutputSpec{Name: "render", Prefix: prefix, Type: c.typ})
+
+			typ := c.typ
+			if typ == "" {
+				typ = "console"
+			}
+			child, err := parsed.Child("output", -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fields := child.GetFields(); !reflect.DeepEqual(fields, []string{typ}) {
+				t.Fatalf("outputs = %v, want %s", fields, typ)
+			}
+			outputs := unpack(t, parsed, "output")
+			output, ok := outputs[typ].(map[string]interface{})
+			if !ok && len(c.want) > 0 {
+				t.Fatalf("output.%s = %#v", typ, outputs[typ])
+			}
+			for k, want := range c.want {
+				if got := output[k]; !reflect.DeepEqual(got, want) {
+					t.Errorf("output.%s.%s = %#v, want %#v", typ, k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	setEnvs(t, "AUDIT_", map[string]string{
+		"ELASTICSEARCH_HOSTS":       "es:9200",
+		"ELASTICSEARCH_SSL_ENABLED": "true",
+		"LOG_INDEX_TEMPLATE":        "tenant-%{[namespace]}",
+		"QUEUE_DISK_MAX_SIZE":       "10GiB",
+	})
+	c, parsed := render(t, OutputSpec{Name: "audit", Prefix: "AUDIT_", Type: "elasticsearch"})
+
+	// TLS 开启时默认使用 https
+	if c.Elasticsearch.Protocol != "https" {
+		t.Errorf("protocol = %q, want https", c.Elasticsearch.Protocol)
+	}
+	if got := c.Elasticsearch.Indices; len(got) != 1 || got[0].Index != "tenant-%{[namespace]}" {
+		t.Errorf("indices = %+v", got)
+	}
+	if c.Setup.TemplateName != "watchlog" || c.Setup.ILMEnabled == nil || *c.Setup.ILMEnabled {
+		t.Errorf("setup = %+v", c.Setup)
+	}
+
+	// 每个输出的采集器使用各自的数据目录
+	queue := unpack(t, parsed, "queue.disk")
+	if queue["path"] != "/var/lib/filebeat/audit/diskqueue" || queue["max_size"] != "10GiB" {
+		t.Errorf("queue.disk = %v", queue)
+	}
+	http := unpack(t, parsed, "http")
+	if http["host"] != "unix:///var/lib/filebeat/audit/filebeat.sock" || http["enabled"] != true {
+		t.Errorf("http = %v", http)
+	}
+	inputs := unpack(t, parsed, "filebeat.config.inputs")
+	if inputs["path"] != "/usr/share/filebeat/inputs.d/*.yml" {
+		t.Errorf("filebeat.config.inputs = %v", inputs)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	cases := []struct {
+		typ  string
+		envs map[string]string
+		err  []string
+	}{
+		{"elasticsearch", nil, []string{"ELASTICSEARCH_HOST required", "ELASTICSEARCH_PORT required"}},
+		{"logstash", map[string]string{"LOGSTASH_HOST": "logstash", "LOGSTASH_PORT": "beats"}, []string{"LOGSTASH_PORT must be a port number"}},
+		{"file", map[string]string{"FILE_PERMISSIONS": "rw"}, []string{"FILE_PATH required", "FILE_PERMISSIONS must be an octal mode"}},
+		{"redis", map[string]string{"REDIS_HOST": "redis", "REDIS_PORT": "6379", "REDIS_TIMEOUT": "5"}, []string{"REDIS_TIMEOUT must be a duration"}},
+		{"kafka", map[string]string{"KAFKA_REQUIRE_ACKS": "2", "KAFKA_PARTITION": "sticky"}, []string{"KAFKA_BROKERS required", "KAFKA_REQUIRE_ACKS must be -1, 0 or 1", "KAFKA_PARTITION must be"}},
+		{"console", map[string]string{"QUEUE_DISK_MAX_SIZE": "big"}, []string{"QUEUE_DISK_MAX_SIZE must be a size"}},
+	}
+	for _, c := range cases {
+		t.Run(c.typ, func(t *testing.T) {
+			prefix := "ERR_" + strings.ToUpper(c.typ) + "_"
+			setEnvs(t, prefix, c.envs)
+			_, err := Load("watchlog", OutputSpec{Name: "err", Prefix: prefix, Type: c.typ}, "/tmp/inputs.d")
+			if err == nil {
+				t.Fatal("Load should fail")
+			}
+			for _, want := range c.err {
+				if !strings.Contains(err.Error(), prefix+want) {
+					t.Errorf("err %q does not contain %s%s", err.Error(), prefix, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOutputValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		output Output
+		err    string
+	}{
+		{"none", Output{}, "exactly one output"},
+		{"two", Output{Count: &Count{}, Console: &Console{}}, "exactly one output"},
+		{"elasticsearch hosts", Output{Elasticsearch: &Elasticsearch{}}, "elasticsearch hosts can not be empty"},
+		{"logstash hosts", Output{Logstash: &Logstash{}}, "logstash hosts can not be empty"},
+		{"file path", Output{File: &File{}}, "file path can not be empty"},
+		{"redis hosts", Output{Redis: &Redis{}}, "redis hosts can not be empty"},
+		{"redis datatype", Output{Redis: &Redis{Hosts: []string{"redis:6379"}, DataType: "set"}}, "redis datatype must be list or channel"},
+		{"kafka hosts", Output{Kafka: &Kafka{}}, "kafka hosts can not be empty"},
+		{"redis", Output{Redis: &Redis{Hosts: []string{"redis:6379"}, DataType: "channel"}}, ""},
+		{"count", Output{Count: &Count{}}, ""},
+	}
+	for _, c := range cases {
+		err := c.output.Validate()
+		if c.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected err %s", c.name, err.Error())
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: err = %v, want %q", c.name, err, c.err)
+		}
+	}
+}
+
+func TestPasswordFile(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "password")
+	if err := ioutil.WriteFile(secret, []byte(password+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	setEnvs(t, "SECRET_", map[string]string{
+		"KAFKA_BROKERS":       "kafka:9092",
+		"KAFKA_USERNAME":      "watchlog",
+		"KAFKA_PASSWORD_FILE": secret,
+	})
+	_, parsed := render(t, OutputSpec{Name: "secret", Prefix: "SECRET_", Type: "kafka"})
+
+	var kafka struct {
+		Username string `config:"username"`
+		Password string `config:"password"`
+	}
+	child, err := parsed.Child("output", -1, ucfg.PathSep("."))
+	if err == nil {
+		child, err = child.Child("kafka", -1, ucfg.PathSep("."))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := child.Unpack(&kafka); err != nil {
+		t.Fatal(err)
+	}
+	if kafka.Username != "watchlog" || kafka.Password != password {
+		t.Errorf("kafka credentials = %q / %q, want watchlog / %q", kafka.Username, kafka.Password, password)
+	}
+}
+
+func TestOutputs(t *testing.T) {
+	t.Setenv("LOGGING_OUTPUT", "kafka")
+	t.Setenv("LOGGING_OUTPUTS", "audit-log, metrics")
+	t.Setenv("AUDIT_LOG_LOGGING_OUTPUT", "elasticsearch")
+	t.Setenv("METRICS_LOGGING_OUTPUT", "loki")
+
+	specs, err := Outputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []OutputSpec{
+		{Name: logtypes.DefaultOutput, Type: "kafka"},
+		{Name: "audit-log", Prefix: "AUDIT_LOG_", Type: "elasticsearch"},
+		{Name: "metrics", Prefix: "METRICS_", Type: "loki"},
+	}
+	if !reflect.DeepEqual(specs, want) {
+		t.Errorf("Outputs() = %+v, want %+v", specs, want)
+	}
+	if specs[1].Native() || !specs[2].Native() {
+		t.Errorf("native = %v %v, want false true", specs[1].Native(), specs[2].Native())
+	}
+
+	t.Setenv("METRICS_LOGGING_OUTPUT", "syslog")
+	if _, err := Outputs(); err == nil || !strings.Contains(err.Error(), "METRICS_LOGGING_OUTPUT") {
+		t.Errorf("unsupported output err = %v", err)
+	}
+	t.Setenv("LOGGING_OUTPUTS", "default")
+	if _, err := Outputs(); err == nil {
+		t.Error("LOGGING_OUTPUTS should not declare the default output")
+	}
+}
@@ -0,0 +1,95 @@
+package bootstrap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"watchlog/pkg/tools"
+)
+
+// SSL 输出的 TLS 配置, 证书均为挂载的文件路径, 由采集器读取
+type SSL struct {
+	Enabled                bool     `yaml:"enabled"`
+	CertificateAuthorities []string `yaml:"certificate_authorities,omitempty"`
+	Certificate            string   `yaml:"certificate,omitempty"`
+	Key                    string   `yaml:"key,omitempty"`
+	KeyPassphrase          string   `yaml:"key_passphrase,omitempty"`
+	VerificationMode       string   `yaml:"verification_mode,omitempty"`
+}
+
+// SASL kafka 认证机制
+type SASL struct {
+	Mechanism string `yaml:"mechanism"`
+}
+
+// loadSSL 读取 {name}_SSL_* 变量, 未配置时返回 nil
+//
+// {name}_SSL_CA_FILE CA 证书, 多个使用逗号分隔
+// {name}_SSL_CERT_FILE / {name}_SSL_KEY_FILE 客户端证书及私钥
+// {name}_SSL_KEY_PASSPHRASE 私钥密码
+// {name}_SSL_VERIFICATION_MODE full, strict, certificate, none
+func loadSSL(r *tools.Env, name string) *SSL {
+	ssl := &SSL{
+		CertificateAuthorities: r.List(name + "_SSL_CA_FILE"),
+		Certificate:            r.Str(name + "_SSL_CERT_FILE"),
+		Key:                    r.Str(name + "_SSL_KEY_FILE"),
+		KeyPassphrase:          r.Str(name + "_SSL_KEY_PASSPHRASE"),
+		VerificationMode:       r.Str(name + "_SSL_VERIFICATION_MODE"),
+	}
+	enabled := r.BoolPtr(name + "_SSL_ENABLED")
+	if enabled == nil && len(ssl.CertificateAuthorities) == 0 && ssl.Certificate == "" && ssl.Key == "" && ssl.VerificationMode == "" {
+		return nil
+	}
+	ssl.Enabled = enabled == nil || *enabled
+
+	for _, ca := range ssl.CertificateAuthorities {
+		data, err := ioutil.ReadFile(ca)
+		if err != nil {
+			r.Errorf("%s%s_SSL_CA_FILE read failed: %s", r.Prefix, name, err.Error())
+			continue
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(data) {
+			r.Errorf("%s%s_SSL_CA_FILE %s contains no PEM certificate", r.Prefix, name, ca)
+		}
+	}
+
+	switch {
+	case ssl.Certificate == "" && ssl.Key == "":
+	case ssl.Certificate == "" || ssl.Key == "":
+		r.Errorf("%s%s_SSL_CERT_FILE and %s%s_SSL_KEY_FILE must be set together", r.Prefix, name, r.Prefix, name)
+	case ssl.KeyPassphrase != "":
+		// 加密的私钥由采集器解密, 这里只检查文件可读
+		for _, f := range []string{ssl.Certificate, ssl.Key} {
+			if _, err := ioutil.ReadFile(f); err != nil {
+				r.Errorf("%s%s_SSL certificate read failed: %s", r.Prefix, name, err.Error())
+			}
+		}
+	default:
+		if _, err := tls.LoadX509KeyPair(ssl.Certificate, ssl.Key); err != nil {
+			r.Errorf("%s%s_SSL_CERT_FILE/%s_SSL_KEY_FILE invalid: %s", r.Prefix, name, name, err.Error())
+		}
+	}
+
+	switch ssl.VerificationMode {
+	case "", "full", "strict", "certificate", "none":
+	default:
+		r.Errorf("%s%s_SSL_VERIFICATION_MODE must be full, strict, certificate or none, got %q", r.Prefix, name, ssl.VerificationMode)
+	}
+	return ssl
+}
+
+// loadSASL 读取 KAFKA_SASL_MECHANISM, 支持 PLAIN, SCRAM-SHA-256, SCRAM-SHA-512
+func loadSASL(r *tools.Env, k *Kafka) *SASL {
+	mechanism := r.Str("KAFKA_SASL_MECHANISM")
+	switch mechanism {
+	case "":
+		return nil
+	case "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512":
+	default:
+		r.Errorf("%sKAFKA_SASL_MECHANISM must be PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512, got %q", r.Prefix, mechanism)
+	}
+	if k.Username == "" || k.Password == "" {
+		r.Errorf("%sKAFKA_USERNAME and %sKAFKA_PASSWORD required by KAFKA_SASL_MECHANISM", r.Prefix, r.Prefix)
+	}
+	return &SASL{Mechanism: mechanism}
+}
@@ -0,0 +1,238 @@
+package bootstrap
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"watchlog/pkg/tools"
+)
+
+// testCerts 生成的 CA 及客户端证书文件
+type testCerts struct {
+	ca, cert, key, otherKey string
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) string {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func generateKey(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, der
+}
+
+// newTestCerts 生成自签名 CA 及由其签发的客户端证书
+func newTestCerts(t *testing.T) testCerts {
+	t.Helper()
+	dir := t.TempDir()
+	caKey, _ := generateKey(t)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "watchlog-test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, keyDER := generateKey(t)
+	client := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "watchlog"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, client, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherDER := generateKey(t)
+
+	return testCerts{
+		ca:       writePEM(t, filepath.Join(dir, "ca.crt"), "CERTIFICATE", caDER),
+		cert:     writePEM(t, filepath.Join(dir, "client.crt"), "CERTIFICATE", certDER),
+		key:      writePEM(t, filepath.Join(dir, "client.key"), "EC PRIVATE KEY", keyDER),
+		otherKey: writePEM(t, filepath.Join(dir, "other.key"), "EC PRIVATE KEY", otherDER),
+	}
+}
+
+func TestLoadSSL(t *testing.T) {
+	certs := newTestCerts(t)
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "ca.txt")
+	if err := ioutil.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.crt")
+
+	type sslCase struct {
+		name string
+		envs map[string]string
+		want *SSL
+		err  string
+	}
+	cases := []sslCase{
+		{name: "not configured"},
+		{name: "disabled", envs: map[string]string{"KAFKA_SSL_ENABLED": "false"}, want: &SSL{}},
+		{name: "enabled", envs: map[string]string{"KAFKA_SSL_ENABLED": "true"}, want: &SSL{Enabled: true}},
+		{
+			name: "ca",
+			envs: map[string]string{"KAFKA_SSL_CA_FILE": certs.ca + "," + certs.ca},
+			want: &SSL{Enabled: true, CertificateAuthorities: []string{certs.ca, certs.ca}},
+		},
+		{
+			name: "client certificate",
+			envs: map[string]string{"KAFKA_SSL_CA_FILE": certs.ca, "KAFKA_SSL_CERT_FILE": certs.cert, "KAFKA_SSL_KEY_FILE": certs.key},
+			want: &SSL{Enabled: true, CertificateAuthorities: []string{certs.ca}, Certificate: certs.cert, Key: certs.key},
+		},
+		{
+			// 加密的私钥只检查文件可读
+			name: "key passphrase",
+			envs: map[string]string{"KAFKA_SSL_CERT_FILE": certs.cert, "KAFKA_SSL_KEY_FILE": certs.otherKey, "KAFKA_SSL_KEY_PASSPHRASE": "secret"},
+			want: &SSL{Enabled: true, Certificate: certs.cert, Key: certs.otherKey, KeyPassphrase: "secret"},
+		},
+		{name: "missing ca", envs: map[string]string{"KAFKA_SSL_CA_FILE": missing}, err: "KAFKA_SSL_CA_FILE read failed"},
+		{name: "ca without certificate", envs: map[string]string{"KAFKA_SSL_CA_FILE": notPEM}, err: "contains no PEM certificate"},
+		{name: "certificate without key", envs: map[string]string{"KAFKA_SSL_CERT_FILE": certs.cert}, err: "must be set together"},
+		{name: "key without certificate", envs: map[string]string{"KAFKA_SSL_KEY_FILE": certs.key}, err: "must be set together"},
+		{
+			name: "mismatched key",
+			envs: map[string]string{"KAFKA_SSL_CERT_FILE": certs.cert, "KAFKA_SSL_KEY_FILE": certs.otherKey},
+			err:  "KAFKA_SSL_CERT_FILE/KAFKA_SSL_KEY_FILE invalid",
+		},
+		{
+			name: "swapped certificate and key",
+			envs: map[string]string{"KAFKA_SSL_CERT_FILE": certs.key, "KAFKA_SSL_KEY_FILE": certs.cert},
+			err:  "KAFKA_SSL_CERT_FILE/KAFKA_SSL_KEY_FILE invalid",
+		},
+		{
+			name: "missing key with passphrase",
+			envs: map[string]string{"KAFKA_SSL_CERT_FILE": certs.cert, "KAFKA_SSL_KEY_FILE": missing, "KAFKA_SSL_KEY_PASSPHRASE": "secret"},
+			err:  "KAFKA_SSL certificate read failed",
+		},
+		{name: "invalid enabled", envs: map[string]string{"KAFKA_SSL_ENABLED": "on"}, err: "KAFKA_SSL_ENABLED must be a bool"},
+	}
+	for _, mode := range []string{"full", "strict", "certificate", "none"} {
+		cases = append(cases, sslCase{name: "verification " + mode, envs: map[string]string{"KAFKA_SSL_VERIFICATION_MODE": mode}, want: &SSL{Enabled: true, VerificationMode: mode}})
+	}
+	for _, mode := range []string{"FULL", "insecure"} {
+		cases = append(cases, sslCase{name: "verification " + mode, envs: map[string]string{"KAFKA_SSL_VERIFICATION_MODE": mode}, err: "KAFKA_SSL_VERIFICATION_MODE must be full, strict, certificate or none"})
+	}
+
+	for i, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			prefix := fmt.Sprintf("SSL%d_", i)
+			setEnvs(t, prefix, c.envs)
+			r := tools.NewEnv(prefix)
+			got := loadSSL(r, "KAFKA")
+			err := r.Err()
+			if c.err != "" {
+				// 错误信息带有输出的前缀
+				if err == nil || !strings.Contains(err.Error(), c.err) || !strings.Contains(err.Error(), prefix+"KAFKA_SSL") {
+					t.Fatalf("err = %v, want %s", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.want == nil {
+				if got != nil {
+					t.Fatalf("loadSSL = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("loadSSL = nil, want %+v", c.want)
+			}
+			if got.Enabled != c.want.Enabled || got.Certificate != c.want.Certificate || got.Key != c.want.Key ||
+				got.KeyPassphrase != c.want.KeyPassphrase || got.VerificationMode != c.want.VerificationMode ||
+				strings.Join(got.CertificateAuthorities, ",") != strings.Join(c.want.CertificateAuthorities, ",") {
+				t.Errorf("loadSSL = %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestLoadSASL(t *testing.T) {
+	cases := []struct {
+		name string
+		envs map[string]string
+		want string
+		err  string
+	}{
+		{name: "not configured", envs: map[string]string{}},
+		{name: "plain", envs: map[string]string{"KAFKA_SASL_MECHANISM": "PLAIN", "KAFKA_USERNAME": "u", "KAFKA_PASSWORD": "p"}, want: "PLAIN"},
+		{name: "scram 256", envs: map[string]string{"KAFKA_SASL_MECHANISM": "SCRAM-SHA-256", "KAFKA_USERNAME": "u", "KAFKA_PASSWORD": "p"}, want: "SCRAM-SHA-256"},
+		{name: "scram 512", envs: map[string]string{"KAFKA_SASL_MECHANISM": "SCRAM-SHA-512", "KAFKA_USERNAME": "u", "KAFKA_PASSWORD": "p"}, want: "SCRAM-SHA-512"},
+		{
+			name: "unsupported",
+			envs: map[string]string{"KAFKA_SASL_MECHANISM": "GSSAPI", "KAFKA_USERNAME": "u", "KAFKA_PASSWORD": "p"},
+			err:  `KAFKA_SASL_MECHANISM must be PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512, got "GSSAPI"`,
+		},
+		{
+			name: "lower case",
+			envs: map[string]string{"KAFKA_SASL_MECHANISM": "scram-sha-512", "KAFKA_USERNAME": "u", "KAFKA_PASSWORD": "p"},
+			err:  "KAFKA_SASL_MECHANISM must be",
+		},
+		{name: "missing password", envs: map[string]string{"KAFKA_SASL_MECHANISM": "PLAIN", "KAFKA_USERNAME": "u"}, err: "KAFKA_PASSWORD required by KAFKA_SASL_MECHANISM"},
+	}
+	for i, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			prefix := fmt.Sprintf("SASL%d_", i)
+			setEnvs(t, prefix, c.envs)
+			r := tools.NewEnv(prefix)
+			k := &Kafka{Username: r.Str("KAFKA_USERNAME"), Password: r.Str("KAFKA_PASSWORD")}
+			got := loadSASL(r, k)
+			err := r.Err()
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("err = %v, want %s", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.want == "" {
+				if got != nil {
+					t.Fatalf("loadSASL = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil || got.Mechanism != c.want {
+				t.Errorf("loadSASL = %+v, want %s", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package bootstrap
+
+import (
+	"fmt"
+)
+
+// Config filebeat.yml 基础配置
+type Config struct {
+	Processors     []map[string]interface{} `yaml:"processors,omitempty"`
+	FilebeatConfig FilebeatConfig           `yaml:"filebeat.config"`
+	Queue          *Queue                   `yaml:"queue,omitempty"`
+	// 采集器的状态接口, 监听 unix socket, WatchLog 从中读取丢弃的事件数
+	HTTPEnabled bool   `yaml:"http.enabled,omitempty"`
+	HTTPHost    string `yaml:"http.host,omitempty"`
+	Output      `yaml:",inline"`
+	Setup       `yaml:",inline"`
+}
+
+type FilebeatConfig struct {
+	Modules Reload `yaml:"modules"`
+	Inputs  Reload `yaml:"inputs"`
+}
+
+type Reload struct {
+	Path    string `yaml:"path"`
+	Enabled bool   `yaml:"reload.enabled"`
+}
+
+// Queue 采集器队列, 未配置时使用内存队列
+type Queue struct {
+	Disk *DiskQueue `yaml:"disk,omitempty"`
+}
+
+// DiskQueue 磁盘队列, 输出不可用时事件写入磁盘, 队列满后暂停采集
+type DiskQueue struct {
+	Path    string `yaml:"path,omitempty"`
+	MaxSize string `yaml:"max_size"`
+}
+
+// Setup elasticsearch 索引模板配置
+type Setup struct {
+	ILMEnabled      *bool  `yaml:"setup.ilm.enabled,omitempty"`
+	TemplateName    string `yaml:"setup.template.name,omitempty"`
+	TemplatePattern string `yaml:"setup.template.pattern,omitempty"`
+}
+
+// Output 采集器输出, 有且仅有一个不为空
+type Output struct {
+	Elasticsearch *Elasticsearch `yaml:"output.elasticsearch,omitempty"`
+	Logstash      *Logstash      `yaml:"output.logstash,omitempty"`
+	File          *File          `yaml:"output.file,omitempty"`
+	Redis         *Redis         `yaml:"output.redis,omitempty"`
+	Kafka         *Kafka         `yaml:"output.kafka,omitempty"`
+	Count         *Count         `yaml:"output.count,omitempty"`
+	Console       *Console       `yaml:"output.console,omitempty"`
+}
+
+// Condition 路由条件
+type Condition struct {
+	HasFields []string `yaml:"has_fields"`
+}
+
+type IndexRoute struct {
+	Index string    `yaml:"index"`
+	When  Condition `yaml:"when"`
+}
+
+type TopicRoute struct {
+	Topic string    `yaml:"topic"`
+	When  Condition `yaml:"when"`
+}
+
+type KeyRoute struct {
+	Key  string    `yaml:"key"`
+	When Condition `yaml:"when"`
+}
+
+type Elasticsearch struct {
+	Hosts       []string     `yaml:"hosts"`
+	Protocol    string       `yaml:"protocol,omitempty"`
+	Username    string       `yaml:"username,omitempty"`
+	Password    string       `yaml:"password,omitempty"`
+	Worker      int          `yaml:"worker,omitempty"`
+	Path        string       `yaml:"path,omitempty"`
+	BulkMaxSize int          `yaml:"bulk_max_size,omitempty"`
+	Index       string       `yaml:"index"`
+	Indices     []IndexRoute `yaml:"indices,omitempty"`
+	SSL         *SSL         `yaml:"ssl,omitempty"`
+}
+
+type Logstash struct {
+	Hosts       []string `yaml:"hosts"`
+	Index       string   `yaml:"index"`
+	Worker      int      `yaml:"worker,omitempty"`
+	LoadBalance *bool    `yaml:"loadbalance,omitempty"`
+	BulkMaxSize int      `yaml:"bulk_max_size,omitempty"`
+	SlowStart   *bool    `yaml:"slow_start,omitempty"`
+}
+
+type File struct {
+	Path          string `yaml:"path"`
+	Filename      string `yaml:"filename,omitempty"`
+	RotateEveryKB int    `yaml:"rotate_every_kb,omitempty"`
+	NumberOfFiles int    `yaml:"number_of_files,omitempty"`
+	Permissions   uint32 `yaml:"permissions,omitempty"`
+}
+
+type Redis struct {
+	Hosts       []string   `yaml:"hosts"`
+	Key         string     `yaml:"key"`
+	Keys        []KeyRoute `yaml:"keys,omitempty"`
+	Worker      int        `yaml:"worker,omitempty"`
+	Password    string     `yaml:"password,omitempty"`
+	DataType    string     `yaml:"datatype,omitempty"`
+	LoadBalance *bool      `yaml:"loadbalance,omitempty"`
+	Timeout     string     `yaml:"timeout,omitempty"`
+	BulkMaxSize int        `yaml:"bulk_max_size,omitempty"`
+	SSL         *SSL       `yaml:"ssl,omitempty"`
+}
+
+type Kafka struct {
+	Hosts             []string                          `yaml:"hosts"`
+	Topic             string                            `yaml:"topic"`
+	Topics            []TopicRoute                      `yaml:"topics,omitempty"`
+	Version           string                            `yaml:"version,omitempty"`
+	Username          string                            `yaml:"username,omitempty"`
+	Password          string                            `yaml:"password,omitempty"`
+	Worker            int                               `yaml:"worker,omitempty"`
+	Key               string                            `yaml:"key,omitempty"`
+	Partition         map[string]map[string]interface{} `yaml:"partition,omitempty"`
+	ClientID          string                            `yaml:"client_id,omitempty"`
+	BulkMaxSize       int                               `yaml:"bulk_max_size,omitempty"`
+	BrokerTimeout     string                            `yaml:"broker_timeout,omitempty"`
+	ChannelBufferSize int                               `yaml:"channel_buffer_size,omitempty"`
+	KeepAlive         string                            `yaml:"keep_alive,omitempty"`
+	MaxMessageBytes   int                               `yaml:"max_message_bytes,omitempty"`
+	RequiredAcks      *int                              `yaml:"required_acks,omitempty"`
+	SSL               *SSL                              `yaml:"ssl,omitempty"`
+	SASL              *SASL                             `yaml:"sasl,omitempty"`
+}
+
+type Count struct{}
+
+type Console struct {
+	Pretty bool `yaml:"pretty"`
+}
+
+// Validate checks exactly one output is configured and its required fields
+func (o Output) Validate() error {
+	var outputs []string
+	if o.Elasticsearch != nil {
+		outputs = append(outputs, "elasticsearch")
+		if len(o.Elasticsearch.Hosts) == 0 {
+			return fmt.Errorf("elasticsearch hosts can not be empty")
+		}
+	}
+	if o.Logstash != nil {
+		outputs = append(outputs, "logstash")
+		if len(o.Logstash.Hosts) == 0 {
+			return fmt.Errorf("logstash hosts can not be empty")
+		}
+	}
+	if o.File != nil {
+		outputs = append(outputs, "file")
+		if o.File.Path == "" {
+			return fmt.Errorf("file path can not be empty")
+		}
+	}
+	if o.Redis != nil {
+		outputs = append(outputs, "redis")
+		if len(o.Redis.Hosts) == 0 {
+			return fmt.Errorf("redis hosts can not be empty")
+		}
+		if o.Redis.DataType != "" && o.Redis.DataType != "list" && o.Redis.DataType != "channel" {
+			return fmt.Errorf("redis datatype must be list or channel, got %q", o.Redis.DataType)
+		}
+	}
+	if o.Kafka != nil {
+		outputs = append(outputs, "kafka")
+		if len(o.Kafka.Hosts) == 0 {
+			return fmt.Errorf("kafka hosts can not be empty")
+		}
+	}
+	if o.Count != nil {
+		outputs = append(outputs, "count")
+	}
+	if o.Console != nil {
+		outputs = append(outputs, "console")
+	}
+
+	if len(outputs) != 1 {
+		return fmt.Errorf("exactly one output must be configured, got %v", outputs)
+	}
+	return nil
+}
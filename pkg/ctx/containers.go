@@ -0,0 +1,104 @@
+package ctx
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	logtypes "watchlog/log/config"
+	"watchlog/pkg/metrics"
+)
+
+// Container WatchLog 管理的容器, 由容器运行时的控制器维护
+type Container struct {
+	ID string `json:"id"`
+	// Labels 写入日志的容器信息, 如 k8s_pod_namespace
+	Labels  map[string]string    `json:"labels"`
+	Configs []logtypes.LogConfig `json:"configs"`
+	// ConfigPaths Filebeat 类型输出的采集配置路径, 键为输出名称
+	ConfigPaths map[string]string `json:"config_paths,omitempty"`
+	// Native 是否由原生采集器采集
+	Native bool `json:"native"`
+	// Paused 是否暂停采集, 暂停期间没有采集配置
+	Paused  bool      `json:"paused,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+// containers 容器索引, 键为容器 ID
+type containers struct {
+	mu    sync.Mutex
+	items map[string]*Container
+}
+
+// TrackContainer 记录容器的采集配置
+func (c *Context) TrackContainer(container Container) {
+	c.containers.mu.Lock()
+	defer c.containers.mu.Unlock()
+	if c.containers.items == nil {
+		c.containers.items = make(map[string]*Container)
+	}
+	container.Updated = time.Now()
+	c.containers.items[container.ID] = &container
+	c.observeContainers()
+}
+
+// UntrackContainer 删除容器的记录
+func (c *Context) UntrackContainer(id string) {
+	c.containers.mu.Lock()
+	defer c.containers.mu.Unlock()
+	delete(c.containers.items, id)
+	c.observeContainers()
+}
+
+// observeContainers 按命名空间更新管理的容器数量, 调用方需持有锁
+func (c *Context) observeContainers() {
+	counts := make(map[string]int)
+	for _, item := range c.containers.items {
+		counts[item.Labels["k8s_pod_namespace"]]++
+	}
+	metrics.ContainersTracked.Reset()
+	for namespace, n := range counts {
+		metrics.ContainersTracked.WithLabelValues(c.Runtime, namespace).Set(float64(n))
+	}
+}
+
+// Containers returns the tracked containers sorted by ID
+func (c *Context) Containers() []Container {
+	c.containers.mu.Lock()
+	defer c.containers.mu.Unlock()
+
+	ret := make([]Container, 0, len(c.containers.items))
+	for _, item := range c.containers.items {
+		ret = append(ret, *item)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+	return ret
+}
+
+// GetContainer 按容器 ID 或唯一的 ID 前缀查找容器
+func (c *Context) GetContainer(id string) (Container, error) {
+	c.containers.mu.Lock()
+	defer c.containers.mu.Unlock()
+
+	if item, ok := c.containers.items[id]; ok {
+		return *item, nil
+	}
+	var found *Container
+	for key, item := range c.containers.items {
+		if !strings.HasPrefix(key, id) {
+			continue
+		}
+		if found != nil {
+			return Container{}, fmt.Errorf("container id %s is ambiguous", id)
+		}
+		found = item
+	}
+	if found == nil {
+		return Container{}, fmt.Errorf("container %s not found", id)
+	}
+	return *found, nil
+}
@@ -5,28 +5,40 @@ import (
 	"github.com/containerd/containerd"
 	"github.com/docker/docker/client"
 	"os"
+	"sort"
 	"sync"
+	"watchlog/pkg/pipeline"
 	"watchlog/pkg/provider"
 	"watchlog/pkg/runtime"
 )
 
 type Context struct {
 	context.Context
-	// 采集器
-	FilebeatPointer provider.FilebeatPointer
+	// 采集器, 键为输出名称, 每个 Filebeat 类型的输出对应一个实例
+	FilebeatPointers map[string]*provider.FilebeatPointer
+	// 原生采集器, 服务于原生类型的输出
+	Tailer *pipeline.Tailer
 	// 日志前缀
-	LogPrefix     string
-	BaseDir       string
+	LogPrefix string
+	BaseDir   string
+	// 容器运行时, docker 或 containerd
+	Runtime       string
 	DockerCli     *client.Client
 	ContainerdCli *containerd.Client
+	// 容器退出后等待日志采集完成再删除的配置
+	removals   removals
+	health     health
+	containers containers
+	pauses     pauses
 	sync.Mutex
 }
 
-func NewContext(baseDir, logPrefix string, f provider.FilebeatPointer) *Context {
+func NewContext(baseDir, logPrefix string, f map[string]*provider.FilebeatPointer, t *pipeline.Tailer) *Context {
 	dockerCli := new(client.Client)
 	containerCli := new(containerd.Client)
 
-	switch os.Getenv("RUNTIME_TYPE") {
+	runtimeType := os.Getenv("RUNTIME_TYPE")
+	switch runtimeType {
 	case "docker":
 		dockerCli = runtime.NewDockerClient()
 	case "containerd":
@@ -34,11 +46,28 @@ func NewContext(baseDir, logPrefix string, f provider.FilebeatPointer) *Context
 	}
 
 	return &Context{
-		Context:         context.Background(),
-		FilebeatPointer: f,
-		LogPrefix:       logPrefix,
-		BaseDir:         baseDir,
-		DockerCli:       dockerCli,
-		ContainerdCli:   containerCli,
+		Context:          context.Background(),
+		FilebeatPointers: f,
+		Tailer:           t,
+		LogPrefix:        logPrefix,
+		BaseDir:          baseDir,
+		Runtime:          runtimeType,
+		DockerCli:        dockerCli,
+		ContainerdCli:    containerCli,
 	}
 }
+
+// Pointers returns the collectors sorted by output name
+func (c *Context) Pointers() []*provider.FilebeatPointer {
+	var names []string
+	for name := range c.FilebeatPointers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pointers := make([]*provider.FilebeatPointer, 0, len(names))
+	for _, name := range names {
+		pointers = append(pointers, c.FilebeatPointers[name])
+	}
+	return pointers
+}
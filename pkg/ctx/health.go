@@ -0,0 +1,51 @@
+package ctx
+
+import (
+	"sync"
+	"time"
+)
+
+// health 初始同步及运行时事件流的状态
+type health struct {
+	mu     sync.Mutex
+	synced bool
+	// streamDown 事件流断开的时间, 恢复后为零值
+	streamDown time.Time
+}
+
+// SetSynced 标记启动时的容器同步已完成
+func (c *Context) SetSynced() {
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+	c.health.synced = true
+}
+
+// Synced returns whether the initial container sync has completed
+func (c *Context) Synced() bool {
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+	return c.health.synced
+}
+
+// StreamDown 记录事件流断开, 持续断开时保留最早的时间
+func (c *Context) StreamDown() {
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+	if c.health.streamDown.IsZero() {
+		c.health.streamDown = time.Now()
+	}
+}
+
+// StreamUp 记录事件流恢复
+func (c *Context) StreamUp() {
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+	c.health.streamDown = time.Time{}
+}
+
+// StreamDownSince returns when the event stream went down, zero if it is up
+func (c *Context) StreamDownSince() time.Time {
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+	return c.health.streamDown
+}
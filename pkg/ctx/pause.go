@@ -0,0 +1,162 @@
+package ctx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"watchlog/pkg/tools"
+)
+
+const (
+	PauseContainer = "container"
+	PausePod       = "pod"
+	PauseNamespace = "namespace"
+)
+
+// Pause 暂停采集的范围, 暂停期间删除采集配置, 采集进度保留
+type Pause struct {
+	// Kind container, pod 或 namespace
+	Kind string `json:"kind"`
+	// Value 容器 ID, {namespace}/{pod} 或命名空间
+	Value string    `json:"value"`
+	Since time.Time `json:"since"`
+}
+
+// Match 判断容器是否在暂停的范围内
+func (p Pause) Match(id string, labels map[string]string) bool {
+	switch p.Kind {
+	case PauseContainer:
+		return id == p.Value
+	case PausePod:
+		return labels["k8s_pod_namespace"]+"/"+labels["k8s_pod"] == p.Value
+	case PauseNamespace:
+		return labels["k8s_pod_namespace"] == p.Value
+	}
+	return false
+}
+
+// pauses 暂停采集的范围, 持久化到 path, WatchLog 重启后保留
+type pauses struct {
+	mu    sync.Mutex
+	path  string
+	items []Pause
+}
+
+// LoadPauses 加载暂停采集的范围, 之后的修改写入 path
+func (c *Context) LoadPauses(path string) error {
+	c.pauses.mu.Lock()
+	defer c.pauses.mu.Unlock()
+
+	c.pauses.path = path
+	c.pauses.items = nil
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &c.pauses.items); err != nil {
+		return fmt.Errorf("parse pauses %s failed, err: %s", path, err.Error())
+	}
+	return nil
+}
+
+// AddPause 暂停采集, 已暂停时返回已有的记录及 false
+func (c *Context) AddPause(kind, value string) (Pause, bool, error) {
+	switch kind {
+	case PauseContainer, PauseNamespace:
+	case PausePod:
+		if ns, pod, ok := strings.Cut(value, "/"); !ok || ns == "" || pod == "" {
+			return Pause{}, false, fmt.Errorf("pod must be {namespace}/{pod}, got %q", value)
+		}
+	default:
+		return Pause{}, false, fmt.Errorf("unknown pause kind %q, must be container, pod or namespace", kind)
+	}
+	if value == "" {
+		return Pause{}, false, fmt.Errorf("%s cannot be empty", kind)
+	}
+
+	c.pauses.mu.Lock()
+	defer c.pauses.mu.Unlock()
+	for _, p := range c.pauses.items {
+		if p.Kind == kind && p.Value == value {
+			return p, false, nil
+		}
+	}
+	p := Pause{Kind: kind, Value: value, Since: time.Now()}
+	c.pauses.items = append(c.pauses.items, p)
+	if err := c.savePauses(); err != nil {
+		c.pauses.items = c.pauses.items[:len(c.pauses.items)-1]
+		return Pause{}, false, err
+	}
+	return p, true, nil
+}
+
+// RemovePause 恢复采集, 返回是否存在该暂停
+func (c *Context) RemovePause(kind, value string) (bool, error) {
+	c.pauses.mu.Lock()
+	defer c.pauses.mu.Unlock()
+	for i, p := range c.pauses.items {
+		if p.Kind != kind || p.Value != value {
+			continue
+		}
+		items := append(append([]Pause{}, c.pauses.items[:i]...), c.pauses.items[i+1:]...)
+		old := c.pauses.items
+		c.pauses.items = items
+		if err := c.savePauses(); err != nil {
+			c.pauses.items = old
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// Paused 返回容器所在的暂停范围, 未暂停时返回 nil
+func (c *Context) Paused(id string, labels map[string]string) *Pause {
+	c.pauses.mu.Lock()
+	defer c.pauses.mu.Unlock()
+	for _, p := range c.pauses.items {
+		if p.Match(id, labels) {
+			return &p
+		}
+	}
+	return nil
+}
+
+// Pauses returns the paused scopes sorted by kind and value
+func (c *Context) Pauses() []Pause {
+	c.pauses.mu.Lock()
+	defer c.pauses.mu.Unlock()
+
+	ret := append(make([]Pause, 0, len(c.pauses.items)), c.pauses.items...)
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Kind != ret[j].Kind {
+			return ret[i].Kind < ret[j].Kind
+		}
+		return ret[i].Value < ret[j].Value
+	})
+	return ret
+}
+
+func (c *Context) savePauses() error {
+	if c.pauses.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(c.pauses.items)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.pauses.path), 0755); err != nil {
+		return err
+	}
+	return tools.WriteFileAtomic(c.pauses.path, data, 0644)
+}
@@ -0,0 +1,91 @@
+package ctx
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Removal 等待日志采集完成后删除的容器配置
+type Removal struct {
+	Container string    `json:"container"`
+	Since     time.Time `json:"since"`
+	// Deadline 超过该时间后不再等待, 直接删除配置
+	Deadline time.Time `json:"deadline"`
+	// Unshipped 尚未采集完成的文件及剩余字节数
+	Unshipped map[string]int64 `json:"unshipped"`
+	canceled  bool
+}
+
+// removals 等待删除的容器配置, 键为容器 ID
+type removals struct {
+	mu    sync.Mutex
+	items map[string]*Removal
+}
+
+// AddRemoval 登记等待删除的容器配置, 已登记时返回 nil
+func (c *Context) AddRemoval(id string, grace time.Duration) *Removal {
+	c.removals.mu.Lock()
+	defer c.removals.mu.Unlock()
+	if c.removals.items == nil {
+		c.removals.items = make(map[string]*Removal)
+	}
+	if _, ok := c.removals.items[id]; ok {
+		return nil
+	}
+
+	now := time.Now()
+	r := &Removal{Container: id, Since: now, Deadline: now.Add(grace)}
+	c.removals.items[id] = r
+	return r
+}
+
+// UpdateRemoval 更新尚未采集完成的文件, 删除已取消时返回 false
+func (c *Context) UpdateRemoval(r *Removal, unshipped map[string]int64) bool {
+	c.removals.mu.Lock()
+	defer c.removals.mu.Unlock()
+	r.Unshipped = unshipped
+	return !r.canceled
+}
+
+// DoneRemoval 结束等待, 删除已取消时返回 false
+func (c *Context) DoneRemoval(r *Removal) bool {
+	c.removals.mu.Lock()
+	defer c.removals.mu.Unlock()
+	if c.removals.items[r.Container] == r {
+		delete(c.removals.items, r.Container)
+	}
+	return !r.canceled
+}
+
+// CancelRemoval 容器重新启动时取消删除, 返回是否存在等待中的删除
+func (c *Context) CancelRemoval(id string) bool {
+	c.removals.mu.Lock()
+	defer c.removals.mu.Unlock()
+	r, ok := c.removals.items[id]
+	if ok {
+		r.canceled = true
+		delete(c.removals.items, id)
+	}
+	return ok
+}
+
+// Removals returns the pending removals sorted by container
+func (c *Context) Removals() []Removal {
+	c.removals.mu.Lock()
+	defer c.removals.mu.Unlock()
+
+	ret := make([]Removal, 0, len(c.removals.items))
+	for _, r := range c.removals.items {
+		item := *r
+		item.Unshipped = make(map[string]int64, len(r.Unshipped))
+		for path, size := range r.Unshipped {
+			item.Unshipped[path] = size
+		}
+		ret = append(ret, item)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Container < ret[j].Container
+	})
+	return ret
+}
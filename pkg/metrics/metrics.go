@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "watchlog"
+
+// Registry WatchLog 的指标, 通过状态接口的 /metrics 暴露
+var Registry = prometheus.NewRegistry()
+
+var (
+	// ContainersTracked 当前管理的需要采集日志的容器
+	ContainersTracked = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "containers_tracked",
+		Help:      "Containers with logging env currently tracked.",
+	}, []string{"runtime", "namespace"})
+
+	// ConfigsWritten 内容变化后实际写入的采集配置
+	ConfigsWritten = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "configs_written_total",
+		Help:      "Collector input configs written because their content changed.",
+	}, []string{"runtime", "namespace", "output"})
+
+	// ConfigsRemoved 删除的采集配置
+	ConfigsRemoved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "configs_removed_total",
+		Help:      "Collector input configs removed.",
+	}, []string{"runtime", "namespace", "output"})
+
+	// ConfigErrors 生成采集配置失败, stage 为 parse、render、validate 或 write
+	ConfigErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_errors_total",
+		Help:      "Errors generating collector input configs by stage.",
+	}, []string{"runtime", "namespace", "stage"})
+
+	// EventStreamReconnects 容器运行时事件流断开后重新订阅
+	EventStreamReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "event_stream_reconnects_total",
+		Help:      "Container runtime event stream reconnects.",
+	}, []string{"runtime"})
+
+	// EventLatency 事件发生到处理完成的时间
+	EventLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "event_latency_seconds",
+		Help:      "Time from a container runtime event to the end of its handling.",
+		Buckets:   []float64{.005, .01, .05, .1, .5, 1, 5, 10, 30, 60},
+	}, []string{"runtime", "action"})
+
+	// CollectorRestarts 采集器退出后重启
+	CollectorRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "collector_restarts_total",
+		Help:      "Collector process restarts.",
+	}, []string{"output"})
+
+	// RateLimitedEvents 原生采集超过日志限速被丢弃的事件
+	RateLimitedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_events_total",
+		Help:      "Events dropped by the per-log rate limit of the native pipeline.",
+	}, []string{"namespace", "container", "log"})
+
+	// RateLimitedBytes 原生采集超过日志限速被丢弃的字节数
+	RateLimitedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_bytes_total",
+		Help:      "Message bytes dropped by the per-log rate limit of the native pipeline.",
+	}, []string{"namespace", "container", "log"})
+
+	// InvalidSequences 原生采集转码时替换为 U+FFFD 的非法字节序列
+	InvalidSequences = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "invalid_sequences_total",
+		Help:      "Invalid byte sequences replaced with U+FFFD while transcoding logs of the native pipeline.",
+	}, []string{"namespace", "container", "log", "encoding"})
+
+	// LokiOverflowEvents 标签组合超出 LOKI_MAX_STREAMS 后写入溢出 stream 的事件
+	LokiOverflowEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "loki_overflow_events_total",
+		Help:      "Events sent to the Loki overflow stream because LOKI_MAX_STREAMS was exceeded.",
+	}, []string{"output"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		ContainersTracked,
+		ConfigsWritten,
+		ConfigsRemoved,
+		ConfigErrors,
+		EventStreamReconnects,
+		EventLatency,
+		CollectorRestarts,
+		RateLimitedEvents,
+		RateLimitedBytes,
+		InvalidSequences,
+		LokiOverflowEvents,
+	)
+}
@@ -0,0 +1,202 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logc"
+	"watchlog/pkg/tools"
+)
+
+const clickhouseTimeFormat = "2006-01-02 15:04:05.000000000"
+
+var clickhouseIdentifier = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// clickhouseParseErrors 解析写入数据失败的错误码, 重试也不会成功, 其他错误(认证、库表不存在等)均重试
+var clickhouseParseErrors = map[string]bool{
+	"6":   true, // CANNOT_PARSE_TEXT
+	"26":  true, // CANNOT_PARSE_QUOTED_STRING
+	"27":  true, // CANNOT_PARSE_INPUT_ASSERTION_FAILED
+	"38":  true, // CANNOT_PARSE_DATE
+	"41":  true, // CANNOT_PARSE_DATETIME
+	"53":  true, // TYPE_MISMATCH
+	"72":  true, // CANNOT_PARSE_NUMBER
+	"117": true, // INCORRECT_DATA
+	"131": true, // TOO_LARGE_STRING_SIZE
+}
+
+func init() {
+	RegisterSink("clickhouse", newClickhouse)
+}
+
+// clickhouse 通过 HTTP 接口批量写入 ClickHouse, 首次写入前创建表
+//
+// CLICKHOUSE_URL 地址, 例如 http://clickhouse:8123
+// CLICKHOUSE_DATABASE 数据库, 默认 default
+// CLICKHOUSE_TABLE 表名, 默认 logs
+// CLICKHOUSE_USERNAME / CLICKHOUSE_PASSWORD 认证信息
+// CLICKHOUSE_TTL 日志保留天数, 仅在创建表时生效
+// CLICKHOUSE_CREATE_TABLE 是否自动创建表, 默认 true
+type clickhouse struct {
+	name     string
+	url      string
+	table    string
+	username string
+	password string
+	ttl      int
+	create   bool
+	created  bool
+	client   *http.Client
+}
+
+func newClickhouse(name string, env *tools.Env) (Sink, error) {
+	c := &clickhouse{
+		name:     name,
+		url:      env.Required("CLICKHOUSE_URL"),
+		username: env.Str("CLICKHOUSE_USERNAME"),
+		password: env.Str("CLICKHOUSE_PASSWORD"),
+		ttl:      env.Int("CLICKHOUSE_TTL"),
+		create:   true,
+		client:   newHTTPClient(env, "CLICKHOUSE"),
+	}
+	if v := env.BoolPtr("CLICKHOUSE_CREATE_TABLE"); v != nil {
+		c.create = *v
+	}
+	if c.url != "" {
+		if u, err := url.Parse(c.url); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			env.Errorf("%sCLICKHOUSE_URL must be a http(s) url, got %q", env.Prefix, c.url)
+		}
+	}
+
+	database, table := env.Str("CLICKHOUSE_DATABASE"), env.Str("CLICKHOUSE_TABLE")
+	if database == "" {
+		database = "default"
+	}
+	if table == "" {
+		table = "logs"
+	}
+	if !clickhouseIdentifier.MatchString(database) {
+		env.Errorf("%sCLICKHOUSE_DATABASE must be an identifier, got %q", env.Prefix, database)
+	}
+	if !clickhouseIdentifier.MatchString(table) {
+		env.Errorf("%sCLICKHOUSE_TABLE must be an identifier, got %q", env.Prefix, table)
+	}
+	c.table = fmt.Sprintf("`%s`.`%s`", database, table)
+	return c, nil
+}
+
+// createTable 建表语句, 按天分区
+func (c *clickhouse) createTable() string {
+	ddl := `CREATE TABLE IF NOT EXISTS ` + c.table + ` (
+    timestamp DateTime64(9, 'UTC'),
+    namespace LowCardinality(String),
+    pod String,
+    container LowCardinality(String),
+    topic LowCardinality(String),
+    message String,
+    fields Map(LowCardinality(String), String)
+) ENGINE = MergeTree
+PARTITION BY toDate(timestamp)
+ORDER BY (namespace, container, timestamp)`
+	if c.ttl > 0 {
+		ddl += fmt.Sprintf("\nTTL toDateTime(timestamp) + INTERVAL %d DAY", c.ttl)
+	}
+	return ddl
+}
+
+type clickhouseRow struct {
+	Timestamp string            `json:"timestamp"`
+	Namespace string            `json:"namespace"`
+	Pod       string            `json:"pod"`
+	Container string            `json:"container"`
+	Topic     string            `json:"topic"`
+	Message   string            `json:"message"`
+	Fields    map[string]string `json:"fields"`
+}
+
+// Send 以 JSONEachRow 格式写入, 写入失败时由 Router 重试, 采集暂停直到写入成功, 只有该批数据无法解析时丢弃
+func (c *clickhouse) Send(ctx context.Context, events []Event) error {
+	if c.create && !c.created {
+		// 建表失败时一直重试, 不丢弃日志
+		if err := c.query(ctx, c.createTable(), nil); err != nil {
+			return fmt.Errorf("create table %s failed, err: %s", c.table, err.Error())
+		}
+		logc.Infof(context.Background(), "output %s created table %s", c.name, c.table)
+		c.created = true
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	now := time.Now()
+	for _, ev := range events {
+		ts := ev.Timestamp
+		if ts.IsZero() {
+			ts = now
+		}
+		row := clickhouseRow{
+			Timestamp: ts.UTC().Format(clickhouseTimeFormat),
+			Message:   ev.Message,
+			Fields:    make(map[string]string),
+		}
+		// 容器字段及 topic 单独存储, 其余字段写入 fields
+		for k, v := range ev.Fields {
+			switch k {
+			case "k8s_pod_namespace":
+				row.Namespace = v
+			case "k8s_pod":
+				row.Pod = v
+			case "k8s_container_name":
+				row.Container = v
+			case "topic":
+				row.Topic = v
+			default:
+				row.Fields[k] = v
+			}
+		}
+		if ev.Stream != "" {
+			row.Fields["stream"] = ev.Stream
+		}
+		row.Fields["source"] = ev.Source
+		if err := enc.Encode(row); err != nil {
+			return Permanent(err)
+		}
+	}
+	err := c.query(ctx, "INSERT INTO "+c.table+" FORMAT JSONEachRow", &body)
+	if _, header := responseStatus(err); header != nil && clickhouseParseErrors[header.Get("X-ClickHouse-Exception-Code")] {
+		return Permanent(err)
+	}
+	return err
+}
+
+// query 执行语句, body 不为空时语句通过 query 参数传递
+func (c *clickhouse) query(ctx context.Context, query string, body *bytes.Buffer) error {
+	u, _ := url.Parse(c.url)
+	q := u.Query()
+	if body == nil {
+		body = bytes.NewBufferString(query)
+	} else {
+		q.Set("query", query)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), body)
+	if err != nil {
+		return Permanent(err)
+	}
+	if c.username != "" {
+		req.Header.Set("X-ClickHouse-User", c.username)
+		req.Header.Set("X-ClickHouse-Key", c.password)
+	}
+	return doHTTP(c.client, req)
+}
+
+func (c *clickhouse) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,203 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// clickhouseResponse 测试服务的响应, code 为 X-ClickHouse-Exception-Code
+type clickhouseResponse struct {
+	status int
+	code   string
+}
+
+// clickhouseServer 记录收到的语句及数据, responses 依次作为响应, 用完后返回 200
+type clickhouseServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	responses []clickhouseResponse
+	queries   []string
+	bodies    []string
+	users     []string
+}
+
+func newClickhouseServer(t *testing.T, responses ...clickhouseResponse) *clickhouseServer {
+	s := &clickhouseServer{responses: responses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mu.Lock()
+		query := r.URL.Query().Get("query")
+		if query == "" {
+			query, body = string(body), nil
+		}
+		s.queries = append(s.queries, query)
+		s.bodies = append(s.bodies, string(body))
+		s.users = append(s.users, r.Header.Get("X-ClickHouse-User"))
+		resp := clickhouseResponse{status: http.StatusOK}
+		if len(s.responses) > 0 {
+			resp, s.responses = s.responses[0], s.responses[1:]
+		}
+		s.mu.Unlock()
+		if resp.code != "" {
+			w.Header().Set("X-ClickHouse-Exception-Code", resp.code)
+		}
+		w.WriteHeader(resp.status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *clickhouseServer) requests() ([]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.queries...), append([]string(nil), s.bodies...)
+}
+
+func clickhouseEvents() []Event {
+	return []Event{{
+		Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC),
+		Message:   "hello",
+		Stream:    "stderr",
+		Source:    "/var/log/c.log",
+		Fields: map[string]string{
+			"k8s_pod_namespace":  "prod",
+			"k8s_pod":            "api-1",
+			"k8s_container_name": "api",
+			"topic":              "app",
+			"team":               "pay",
+		},
+	}}
+}
+
+func TestClickhouseCreateAndInsert(t *testing.T) {
+	srv := newClickhouseServer(t)
+	sink := newTestSink(t, "clickhouse", "ch", map[string]string{
+		"CLICKHOUSE_URL":      srv.URL,
+		"CLICKHOUSE_DATABASE": "logs_db",
+		"CLICKHOUSE_TABLE":    "app_logs",
+		"CLICKHOUSE_USERNAME": "writer",
+		"CLICKHOUSE_TTL":      "7",
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := sink.Send(context.Background(), clickhouseEvents()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	queries, bodies := srv.requests()
+	// 只在首次写入前建表
+	if len(queries) != 3 {
+		t.Fatalf("got queries %q, want create table and two inserts", queries)
+	}
+	create := queries[0]
+	for _, want := range []string{
+		"CREATE TABLE IF NOT EXISTS `logs_db`.`app_logs`",
+		"timestamp DateTime64(9, 'UTC')",
+		"fields Map(LowCardinality(String), String)",
+		"ENGINE = MergeTree",
+		"PARTITION BY toDate(timestamp)",
+		"ORDER BY (namespace, container, timestamp)",
+		"TTL toDateTime(timestamp) + INTERVAL 7 DAY",
+	} {
+		if !strings.Contains(create, want) {
+			t.Errorf("create table statement misses %q:\n%s", want, create)
+		}
+	}
+	if queries[1] != "INSERT INTO `logs_db`.`app_logs` FORMAT JSONEachRow" {
+		t.Errorf("insert query = %q", queries[1])
+	}
+	if srv.users[1] != "writer" {
+		t.Errorf("X-ClickHouse-User = %q, want writer", srv.users[1])
+	}
+
+	// JSONEachRow 每行一条日志
+	lines := strings.Split(strings.TrimSpace(bodies[1]), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("insert body has %d rows, want 1: %s", len(lines), bodies[1])
+	}
+	var row map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &row); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"timestamp": "2024-01-02 03:04:05.000006000",
+		"namespace": "prod",
+		"pod":       "api-1",
+		"container": "api",
+		"topic":     "app",
+		"message":   "hello",
+		"fields":    map[string]interface{}{"team": "pay", "stream": "stderr", "source": "/var/log/c.log"},
+	}
+	for k, v := range want {
+		if got, _ := json.Marshal(row[k]); string(got) != mustJSON(v) {
+			t.Errorf("row %s = %s, want %s", k, got, mustJSON(v))
+		}
+	}
+}
+
+func mustJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func TestClickhouseRetry(t *testing.T) {
+	cases := []struct {
+		name      string
+		resp      clickhouseResponse
+		permanent bool
+	}{
+		{"auth failed", clickhouseResponse{http.StatusUnauthorized, "516"}, false},
+		{"forbidden", clickhouseResponse{http.StatusForbidden, "497"}, false},
+		{"unknown table", clickhouseResponse{http.StatusNotFound, "60"}, false},
+		{"unknown database", clickhouseResponse{http.StatusNotFound, "81"}, false},
+		{"syntax error", clickhouseResponse{http.StatusBadRequest, "62"}, false},
+		{"bad request without code", clickhouseResponse{http.StatusBadRequest, ""}, false},
+		{"unavailable", clickhouseResponse{http.StatusServiceUnavailable, ""}, false},
+		{"parse error", clickhouseResponse{http.StatusBadRequest, "27"}, true},
+		{"type mismatch", clickhouseResponse{http.StatusBadRequest, "53"}, true},
+	}
+	for _, c := range cases {
+		// 第一次建表成功, 第二次写入返回错误
+		srv := newClickhouseServer(t, clickhouseResponse{status: http.StatusOK}, c.resp)
+		sink := newTestSink(t, "clickhouse", "retry", map[string]string{"CLICKHOUSE_URL": srv.URL})
+		err := sink.Send(context.Background(), clickhouseEvents())
+		var permanent *permanentError
+		if err == nil || errors.As(err, &permanent) != c.permanent {
+			t.Errorf("%s: Send = %v, want permanent %v", c.name, err, c.permanent)
+		}
+	}
+}
+
+func TestClickhouseCreateTableRetry(t *testing.T) {
+	srv := newClickhouseServer(t, clickhouseResponse{http.StatusUnauthorized, "516"})
+	sink := newTestSink(t, "clickhouse", "create", map[string]string{"CLICKHOUSE_URL": srv.URL})
+
+	// 建表失败时整批重试, 不写入数据
+	r := NewRouter()
+	defer r.Close()
+	if err := r.Add("create", sink, Batch{Size: 10, Wait: 10 * time.Millisecond, MaxBackoff: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.Route(ctx, []string{"create"}, clickhouseEvents()); err != nil {
+		t.Fatal(err)
+	}
+
+	queries, bodies := srv.requests()
+	if len(queries) != 3 || !strings.HasPrefix(queries[0], "CREATE TABLE") || !strings.HasPrefix(queries[1], "CREATE TABLE") || !strings.HasPrefix(queries[2], "INSERT INTO") {
+		t.Fatalf("got queries %q, want create table retried before insert", queries)
+	}
+	if !strings.Contains(bodies[2], `"message":"hello"`) {
+		t.Errorf("insert body = %s", bodies[2])
+	}
+}
@@ -0,0 +1,153 @@
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"watchlog/pkg/tools"
+)
+
+// newHTTPClient 原生 HTTP 输出的客户端, {name}_TIMEOUT 请求超时, 默认 10s
+func newHTTPClient(env *tools.Env, name string) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = loadTLS(env, name)
+	return &http.Client{Timeout: loadTimeout(env, name), Transport: transport}
+}
+
+func loadTimeout(env *tools.Env, name string) time.Duration {
+	timeout := 10 * time.Second
+	if v := env.Duration(name + "_TIMEOUT"); v != "" {
+		timeout, _ = time.ParseDuration(v)
+	}
+	return timeout
+}
+
+// loadTLS 读取原生输出的 TLS 配置
+//
+// {name}_SSL_CA_FILE CA 证书, 多个使用逗号分隔
+// {name}_SSL_CERT_FILE / {name}_SSL_KEY_FILE 客户端证书及私钥
+// {name}_SSL_VERIFICATION_MODE full 或 none
+func loadTLS(env *tools.Env, name string) *tls.Config {
+	cfg := &tls.Config{}
+	if cas := env.List(name + "_SSL_CA_FILE"); len(cas) > 0 {
+		pool := x509.NewCertPool()
+		for _, ca := range cas {
+			data, err := ioutil.ReadFile(ca)
+			if err != nil {
+				env.Errorf("%s%s_SSL_CA_FILE read failed: %s", env.Prefix, name, err.Error())
+				continue
+			}
+			if !pool.AppendCertsFromPEM(data) {
+				env.Errorf("%s%s_SSL_CA_FILE %s contains no PEM certificate", env.Prefix, name, ca)
+			}
+		}
+		cfg.RootCAs = pool
+	}
+
+	cert, key := env.Str(name+"_SSL_CERT_FILE"), env.Str(name+"_SSL_KEY_FILE")
+	switch {
+	case cert == "" && key == "":
+	case cert == "" || key == "":
+		env.Errorf("%s%s_SSL_CERT_FILE and %s%s_SSL_KEY_FILE must be set together", env.Prefix, name, env.Prefix, name)
+	default:
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			env.Errorf("%s%s_SSL_CERT_FILE/%s_SSL_KEY_FILE invalid: %s", env.Prefix, name, name, err.Error())
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+
+	switch mode := env.Str(name + "_SSL_VERIFICATION_MODE"); mode {
+	case "", "full":
+	case "none":
+		cfg.InsecureSkipVerify = true
+	default:
+		env.Errorf("%s%s_SSL_VERIFICATION_MODE must be full or none, got %q", env.Prefix, name, mode)
+	}
+	return cfg
+}
+
+// httpError 非 2xx 的响应
+type httpError struct {
+	status int
+	header http.Header
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+// responseStatus 返回 doHTTP 错误的状态码及响应头, 请求未得到响应时返回 0
+func responseStatus(err error) (int, http.Header) {
+	var e *httpError
+	if errors.As(err, &e) {
+		return e.status, e.header
+	}
+	return 0, nil
+}
+
+// doHTTP 执行请求, 非 2xx 状态码返回 *httpError, 均可以重试
+//
+// 认证失败、库表不存在等错误在修复配置后即可成功, 丢弃会丢失日志, 只有输出确认是该批数据本身的错误时才标记为 Permanent.
+func doHTTP(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &httpError{
+		status: resp.StatusCode,
+		header: resp.Header,
+		err:    fmt.Errorf("%s %s: %s %s", req.Method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body))),
+	}
+}
+
+// loadHeaders 读取 {name}_HEADERS, 格式为 k=v,k=v
+func loadHeaders(env *tools.Env, name string) map[string]string {
+	headers := make(map[string]string)
+	for _, h := range env.List(name + "_HEADERS") {
+		k, v, ok := strings.Cut(h, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			env.Errorf("%s%s_HEADERS must be k=v, got %q", env.Prefix, name, h)
+			continue
+		}
+		headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return headers
+}
+
+// loadGzip 读取 {name}_COMPRESSION, 支持 gzip 及 none
+func loadGzip(env *tools.Env, name string) bool {
+	switch compression := env.Str(name + "_COMPRESSION"); compression {
+	case "", "none":
+	case "gzip":
+		return true
+	default:
+		env.Errorf("%s%s_COMPRESSION must be gzip or none, got %q", env.Prefix, name, compression)
+	}
+	return false
+}
+
+func gzipBytes(data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, _ = w.Write(data)
+	_ = w.Close()
+	return buf.Bytes()
+}
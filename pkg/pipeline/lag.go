@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"sort"
+	"time"
+)
+
+// FileLag 日志文件的采集延迟
+type FileLag struct {
+	Container string `json:"container"`
+	Namespace string `json:"namespace,omitempty"`
+	Output    string `json:"output"`
+	// Type native 为原生采集器, filebeat 为采集器
+	Type   string `json:"type"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Offset int64  `json:"offset"`
+	// Lag 未采集的字节数, 文件被截断时为文件大小
+	Lag      int64      `json:"lag"`
+	LastRead *time.Time `json:"last_read,omitempty"`
+}
+
+// ContainerLag 容器全部日志文件的采集延迟
+type ContainerLag struct {
+	Container string     `json:"container"`
+	Namespace string     `json:"namespace,omitempty"`
+	Lag       int64      `json:"lag"`
+	LastRead  *time.Time `json:"last_read,omitempty"`
+	Files     []FileLag  `json:"files"`
+}
+
+// NewFileLag 根据文件大小及采集进度计算延迟, updated 为零值表示尚未采集
+func NewFileLag(container, output, typ, path string, size, offset int64, updated time.Time) FileLag {
+	lag := size - offset
+	if offset > size {
+		lag = size
+	}
+	l := FileLag{Container: container, Output: output, Type: typ, Path: path, Size: size, Offset: offset, Lag: lag}
+	if !updated.IsZero() {
+		l.LastRead = &updated
+	}
+	return l
+}
+
+// GroupLag 按容器汇总文件的采集延迟, 结果按容器排序
+func GroupLag(files []FileLag) []ContainerLag {
+	index := make(map[string]*ContainerLag)
+	for _, f := range files {
+		c, ok := index[f.Container]
+		if !ok {
+			c = &ContainerLag{Container: f.Container, Namespace: f.Namespace}
+			index[f.Container] = c
+		}
+		c.Lag += f.Lag
+		if f.LastRead != nil && (c.LastRead == nil || f.LastRead.After(*c.LastRead)) {
+			c.LastRead = f.LastRead
+		}
+		c.Files = append(c.Files, f)
+	}
+
+	ret := make([]ContainerLag, 0, len(index))
+	for _, c := range index {
+		sort.Slice(c.Files, func(i, j int) bool {
+			if c.Files[i].Path != c.Files[j].Path {
+				return c.Files[i].Path < c.Files[j].Path
+			}
+			return c.Files[i].Output < c.Files[j].Output
+		})
+		ret = append(ret, *c)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Container < ret[j].Container
+	})
+	return ret
+}
@@ -0,0 +1,232 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zeromicro/go-zero/core/logc"
+	"watchlog/pkg/metrics"
+	"watchlog/pkg/tools"
+)
+
+const (
+	lokiPushPath = "/loki/api/v1/push"
+	// lokiStreamTTL 超过该时间未出现的标签组合不再计入基数
+	lokiStreamTTL = time.Hour
+	// lokiOverflowLabel 超出基数限制的事件写入该标签的 stream
+	lokiOverflowLabel = "watchlog_overflow"
+)
+
+// lokiDefaultLabels 默认映射为 stream 标签的字段, k8s_pod 等高基数字段需要显式配置
+var lokiDefaultLabels = []string{"k8s_pod_namespace", "k8s_container_name", "topic", "stream"}
+
+var lokiLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func init() {
+	RegisterSink("loki", newLoki)
+}
+
+// lokiLabel stream 标签及其取值的字段
+type lokiLabel struct {
+	name  string
+	field string
+}
+
+// loki 通过 push API 发送到 Grafana Loki
+//
+// LOKI_URL loki 地址, 例如 http://loki:3100, 未指定路径时使用 /loki/api/v1/push
+// LOKI_TENANT_ID 多租户时的 X-Scope-OrgID
+// LOKI_USERNAME / LOKI_PASSWORD basic 认证
+// LOKI_LABELS 映射为标签的字段, 逗号分隔, label=field 可重命名, 例如 namespace=k8s_pod_namespace
+// LOKI_MAX_STREAMS 标签组合的数量上限, 默认 1000, 超出的事件写入 watchlog_overflow="true"
+type loki struct {
+	name       string
+	url        string
+	tenant     string
+	username   string
+	password   string
+	labels     []lokiLabel
+	maxStreams int
+	client     *http.Client
+	// streams 标签组合最后出现的时间, 只在 Router 的发送协程中访问
+	streams map[string]time.Time
+	// overflowed 写入溢出 stream 的事件
+	overflowed prometheus.Counter
+	warned     time.Time
+}
+
+func newLoki(name string, env *tools.Env) (Sink, error) {
+	l := &loki{
+		name:       name,
+		url:        env.Required("LOKI_URL"),
+		tenant:     env.Str("LOKI_TENANT_ID"),
+		username:   env.Str("LOKI_USERNAME"),
+		password:   env.Str("LOKI_PASSWORD"),
+		maxStreams: env.Int("LOKI_MAX_STREAMS"),
+		client:     newHTTPClient(env, "LOKI"),
+		streams:    make(map[string]time.Time),
+		overflowed: metrics.LokiOverflowEvents.WithLabelValues(name),
+	}
+	if l.maxStreams == 0 {
+		l.maxStreams = 1000
+	}
+
+	if l.url != "" {
+		u, err := url.Parse(l.url)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			env.Errorf("%sLOKI_URL must be a http(s) url, got %q", env.Prefix, l.url)
+		} else if u.Path == "" || u.Path == "/" {
+			u.Path = lokiPushPath
+			l.url = u.String()
+		}
+	}
+
+	fields := env.List("LOKI_LABELS")
+	if len(fields) == 0 {
+		fields = lokiDefaultLabels
+	}
+	seen := make(map[string]bool)
+	for _, f := range fields {
+		label := lokiLabel{name: f, field: f}
+		if k, v, ok := strings.Cut(f, "="); ok {
+			label = lokiLabel{name: strings.TrimSpace(k), field: strings.TrimSpace(v)}
+		}
+		if !lokiLabelName.MatchString(label.name) || strings.HasPrefix(label.name, "__") || label.name == lokiOverflowLabel {
+			env.Errorf("%sLOKI_LABELS has invalid label name %q", env.Prefix, label.name)
+			continue
+		}
+		if label.field == "" || seen[label.name] {
+			env.Errorf("%sLOKI_LABELS has empty or duplicate label %q", env.Prefix, f)
+			continue
+		}
+		seen[label.name] = true
+		l.labels = append(l.labels, label)
+	}
+	return l, nil
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+type lokiPush struct {
+	Streams []*lokiStream `json:"streams"`
+}
+
+func (l *loki) Send(ctx context.Context, events []Event) error {
+	now := time.Now()
+	for key, seen := range l.streams {
+		if now.Sub(seen) > lokiStreamTTL {
+			delete(l.streams, key)
+		}
+	}
+
+	// 同一 stream 内按时间排序
+	sorted := make([]Event, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+
+	streams := make(map[string]*lokiStream)
+	var keys []string
+	for _, ev := range sorted {
+		labels, key := l.streamLabels(ev, now)
+		s, ok := streams[key]
+		if !ok {
+			s = &lokiStream{Stream: labels}
+			streams[key] = s
+			keys = append(keys, key)
+		}
+		ts := ev.Timestamp
+		if ts.IsZero() {
+			ts = now
+		}
+		s.Values = append(s.Values, [2]string{strconv.FormatInt(ts.UnixNano(), 10), ev.Message})
+	}
+
+	push := lokiPush{}
+	sort.Strings(keys)
+	for _, key := range keys {
+		push.Streams = append(push.Streams, streams[key])
+	}
+
+	body, err := json.Marshal(push)
+	if err != nil {
+		return Permanent(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.url, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if l.tenant != "" {
+		req.Header.Set("X-Scope-OrgID", l.tenant)
+	}
+	if l.username != "" {
+		req.SetBasicAuth(l.username, l.password)
+	}
+	err = doHTTP(l.client, req)
+	// 400 为该批日志被拒绝, 例如时间过旧或乱序, 重试也不会成功
+	if status, _ := responseStatus(err); status == http.StatusBadRequest {
+		return Permanent(err)
+	}
+	return err
+}
+
+// streamLabels 返回事件的标签及 stream key, 新的标签组合超出 LOKI_MAX_STREAMS 时使用溢出 stream
+func (l *loki) streamLabels(ev Event, now time.Time) (map[string]string, string) {
+	labels := make(map[string]string)
+	var parts []string
+	for _, label := range l.labels {
+		v := eventField(ev, label.field)
+		if v == "" {
+			continue
+		}
+		labels[label.name] = v
+		parts = append(parts, label.name+"="+strconv.Quote(v))
+	}
+	key := strings.Join(parts, ",")
+	if len(labels) == 0 {
+		// loki 要求至少一个标签
+		labels["job"] = "watchlog"
+	}
+
+	if _, ok := l.streams[key]; ok || len(l.streams) < l.maxStreams {
+		l.streams[key] = now
+		return labels, key
+	}
+
+	l.overflowed.Inc()
+	if now.Sub(l.warned) > time.Minute {
+		l.warned = now
+		logc.Errorf(context.Background(), "output %s exceeds %d loki streams, events of {%s} are sent to {%s=\"true\"}", l.name, l.maxStreams, key, lokiOverflowLabel)
+	}
+	return map[string]string{lokiOverflowLabel: "true"}, lokiOverflowLabel
+}
+
+func (l *loki) Close() error {
+	l.client.CloseIdleConnections()
+	return nil
+}
+
+// eventField 返回事件的字段, stream 及 source 为事件自身的属性
+func eventField(ev Event, field string) string {
+	switch field {
+	case "stream":
+		return ev.Stream
+	case "source":
+		return ev.Source
+	}
+	return ev.Fields[field]
+}
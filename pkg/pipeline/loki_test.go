@@ -0,0 +1,188 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"watchlog/pkg/metrics"
+	"watchlog/pkg/tools"
+)
+
+// pushServer 记录收到的请求, statuses 依次作为响应状态码, 用完后返回 204
+type pushServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newPushServer(t *testing.T, statuses ...int) *pushServer {
+	s := &pushServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mu.Lock()
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		status := http.StatusNoContent
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		s.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *pushServer) last() (*http.Request, []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.requests) == 0 {
+		return nil, nil
+	}
+	return s.requests[len(s.requests)-1], s.bodies[len(s.bodies)-1]
+}
+
+func (s *pushServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+// newTestSink 按 envs 创建原生输出, envs 的键不带输出前缀
+func newTestSink(t *testing.T, typ, name string, envs map[string]string) Sink {
+	t.Helper()
+	prefix := "TEST_" + name + "_"
+	for k, v := range envs {
+		t.Setenv(prefix+k, v)
+	}
+	sink, err := NewSink(typ, name, tools.NewEnv(prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sink.Close() })
+	return sink
+}
+
+func lokiEvent(ns, container, message string, ts time.Time) Event {
+	return Event{
+		Timestamp: ts,
+		Message:   message,
+		Stream:    "stdout",
+		Fields:    map[string]string{"k8s_pod_namespace": ns, "k8s_container_name": container, "k8s_pod": "p-1"},
+	}
+}
+
+func decodePush(t *testing.T, body []byte) lokiPush {
+	t.Helper()
+	var push lokiPush
+	if err := json.Unmarshal(body, &push); err != nil {
+		t.Fatalf("invalid push body %s: %v", body, err)
+	}
+	return push
+}
+
+func TestLokiLabels(t *testing.T) {
+	srv := newPushServer(t)
+	sink := newTestSink(t, "loki", "labels", map[string]string{
+		"LOKI_URL":       srv.URL,
+		"LOKI_TENANT_ID": "team-a",
+		"LOKI_LABELS":    "namespace=k8s_pod_namespace,k8s_container_name,stream",
+	})
+
+	t0 := time.Unix(1700000000, 0)
+	events := []Event{
+		lokiEvent("prod", "api", "second", t0.Add(time.Second)),
+		lokiEvent("prod", "api", "first", t0),
+		lokiEvent("dev", "api", "other", t0),
+	}
+	if err := sink.Send(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+
+	req, body := srv.last()
+	if req.URL.Path != lokiPushPath || req.Header.Get("X-Scope-OrgID") != "team-a" {
+		t.Errorf("request %s with tenant %q, want %s with team-a", req.URL.Path, req.Header.Get("X-Scope-OrgID"), lokiPushPath)
+	}
+	push := decodePush(t, body)
+	if len(push.Streams) != 2 {
+		t.Fatalf("got %d streams, want 2", len(push.Streams))
+	}
+	// stream 按标签排序, k8s_pod 不在标签中
+	dev, prod := push.Streams[0], push.Streams[1]
+	if want := map[string]string{"namespace": "dev", "k8s_container_name": "api", "stream": "stdout"}; !reflect.DeepEqual(dev.Stream, want) {
+		t.Errorf("dev labels = %v, want %v", dev.Stream, want)
+	}
+	if prod.Stream["namespace"] != "prod" {
+		t.Errorf("prod labels = %v", prod.Stream)
+	}
+	// 同一 stream 内按时间排序
+	want := [][2]string{{"1700000000000000000", "first"}, {"1700000001000000000", "second"}}
+	if !reflect.DeepEqual(prod.Values, want) {
+		t.Errorf("prod values = %v, want %v", prod.Values, want)
+	}
+}
+
+func TestLokiOverflow(t *testing.T) {
+	srv := newPushServer(t)
+	sink := newTestSink(t, "loki", "overflow", map[string]string{
+		"LOKI_URL":         srv.URL,
+		"LOKI_LABELS":      "k8s_pod_namespace",
+		"LOKI_MAX_STREAMS": "1",
+	})
+	overflowed := metrics.LokiOverflowEvents.WithLabelValues("overflow")
+	before := testutil.ToFloat64(overflowed)
+
+	t0 := time.Unix(1700000000, 0)
+	events := []Event{lokiEvent("a", "c", "1", t0), lokiEvent("b", "c", "2", t0), lokiEvent("b", "c", "3", t0), lokiEvent("a", "c", "4", t0)}
+	if err := sink.Send(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+
+	_, body := srv.last()
+	push := decodePush(t, body)
+	streams := make(map[string]int)
+	for _, s := range push.Streams {
+		streams[s.Stream["k8s_pod_namespace"]+s.Stream[lokiOverflowLabel]] = len(s.Values)
+	}
+	if want := map[string]int{"a": 2, "true": 2}; !reflect.DeepEqual(streams, want) {
+		t.Errorf("stream sizes = %v, want %v", streams, want)
+	}
+	if got := testutil.ToFloat64(overflowed) - before; got != 2 {
+		t.Errorf("overflow counter increased by %v, want 2", got)
+	}
+}
+
+func TestLokiRetry(t *testing.T) {
+	srv := newPushServer(t, http.StatusTooManyRequests, http.StatusBadRequest)
+	sink := newTestSink(t, "loki", "retry", map[string]string{"LOKI_URL": srv.URL})
+	o := &output{name: "retry", sink: sink}
+	events := []Event{lokiEvent("a", "c", "1", time.Now())}
+
+	// 429 可以重试
+	ok, err := o.deliver(context.Background(), events)
+	var permanent *permanentError
+	if ok || err == nil || errors.As(err, &permanent) {
+		t.Fatalf("deliver on 429 = %v, %v, want a retryable error", ok, err)
+	}
+	// 400 为该批日志被拒绝, 重试也不会成功
+	if err := sink.Send(context.Background(), events); !errors.As(err, &permanent) {
+		t.Errorf("Send on 400 = %v, want a permanent error", err)
+	}
+	if err := sink.Send(context.Background(), events); err != nil {
+		t.Errorf("Send after recovery = %v", err)
+	}
+	if n := srv.count(); n != 3 {
+		t.Errorf("server got %d requests, want 3", n)
+	}
+}
@@ -0,0 +1,85 @@
+package pipeline
+
+import (
+	"regexp"
+	"time"
+	logtypes "watchlog/log/config"
+)
+
+const (
+	multilineMaxLines = 500
+	multilineTimeout  = 5 * time.Second
+)
+
+// multiline 按 pattern / negate / match 合并多行, 语义与 filebeat 一致
+type multiline struct {
+	pattern *regexp.Regexp
+	negate  bool
+	match   string
+	buf     *Event
+	lines   int
+	last    time.Time
+}
+
+func newMultiline(cfg *logtypes.Multiline) *multiline {
+	if cfg == nil {
+		return nil
+	}
+	return &multiline{
+		pattern: regexp.MustCompile(cfg.Pattern),
+		negate:  cfg.Negate,
+		match:   cfg.Match,
+	}
+}
+
+// add 添加一行, 返回已经完整的事件
+func (m *multiline) add(ev Event) []Event {
+	m.last = time.Now()
+	matched := m.pattern.MatchString(ev.Message) != m.negate
+
+	var done []Event
+	switch m.match {
+	case "before":
+		// 匹配的行与下一行合并
+		m.append(ev)
+		if !matched {
+			done = append(done, m.flush()...)
+		}
+	default:
+		// 匹配的行追加到上一行之后
+		if !matched {
+			done = append(done, m.flush()...)
+		}
+		m.append(ev)
+	}
+
+	if m.lines >= multilineMaxLines {
+		done = append(done, m.flush()...)
+	}
+	return done
+}
+
+func (m *multiline) append(ev Event) {
+	if m.buf == nil {
+		m.buf = &ev
+		m.lines = 1
+		return
+	}
+	m.buf.Message += "\n" + ev.Message
+	m.buf.Offset = ev.Offset
+	m.lines++
+}
+
+// expired returns whether the buffered event has waited longer than the timeout
+func (m *multiline) expired() bool {
+	return m.buf != nil && time.Since(m.last) > multilineTimeout
+}
+
+func (m *multiline) flush() []Event {
+	if m.buf == nil {
+		return nil
+	}
+	ev := *m.buf
+	m.buf, m.lines = nil, 0
+	return []Event{ev}
+}
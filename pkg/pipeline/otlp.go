@@ -0,0 +1,354 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logc"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"watchlog/pkg/tools"
+)
+
+const (
+	otlpProtocolGRPC = "grpc"
+	otlpProtocolHTTP = "http/protobuf"
+	otlpLogsPath     = "/v1/logs"
+	otlpScope        = "watchlog"
+)
+
+// otlpResourceKeys 容器字段对应的 k8s 资源属性, 其余字段及日志 tags 使用原名称
+var otlpResourceKeys = map[string]string{
+	"k8s_pod":            "k8s.pod.name",
+	"k8s_pod_namespace":  "k8s.namespace.name",
+	"k8s_container_name": "k8s.container.name",
+	"k8s_node_name":      "k8s.node.name",
+}
+
+func init() {
+	RegisterSink("otlp", newOTLP)
+}
+
+// otlp 以 OTLP/gRPC 或 OTLP/HTTP 发送到 OpenTelemetry Collector
+//
+// OTLP_ENDPOINT grpc 为 host:port, 例如 otel-collector:4317; http 为地址, 未指定路径时使用 /v1/logs
+// OTLP_PROTOCOL grpc 或 http/protobuf, 默认 grpc
+// OTLP_HEADERS 请求头, 格式为 k=v,k=v, 例如认证信息
+// OTLP_COMPRESSION gzip
+// OTLP_INSECURE grpc 不使用 TLS
+type otlp struct {
+	name     string
+	protocol string
+	endpoint string
+	headers  map[string]string
+	gzip     bool
+	service  string
+	timeout  time.Duration
+	client   *http.Client
+	conn     *grpc.ClientConn
+	logs     collogspb.LogsServiceClient
+}
+
+func newOTLP(name string, env *tools.Env) (Sink, error) {
+	o := &otlp{
+		name:     name,
+		protocol: env.Str("OTLP_PROTOCOL"),
+		endpoint: env.Required("OTLP_ENDPOINT"),
+		headers:  loadHeaders(env, "OTLP"),
+		gzip:     loadGzip(env, "OTLP"),
+		service:  env.Str("OTLP_SERVICE_NAME"),
+		timeout:  loadTimeout(env, "OTLP"),
+	}
+
+	switch o.protocol {
+	case "", otlpProtocolGRPC:
+		o.protocol = otlpProtocolGRPC
+		o.dialGRPC(env)
+	case otlpProtocolHTTP:
+		o.client = newHTTPClient(env, "OTLP")
+		if o.endpoint != "" {
+			u, err := url.Parse(o.endpoint)
+			if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+				env.Errorf("%sOTLP_ENDPOINT must be a http(s) url, got %q", env.Prefix, o.endpoint)
+			} else if u.Path == "" || u.Path == "/" {
+				u.Path = otlpLogsPath
+				o.endpoint = u.String()
+			}
+		}
+	default:
+		env.Errorf("%sOTLP_PROTOCOL must be grpc or http/protobuf, got %q", env.Prefix, o.protocol)
+	}
+	return o, nil
+}
+
+// dialGRPC 创建 grpc 连接, 连接在首次发送时建立
+func (o *otlp) dialGRPC(env *tools.Env) {
+	if o.endpoint == "" {
+		return
+	}
+	target, plaintext := o.endpoint, false
+	if u, err := url.Parse(o.endpoint); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		target, plaintext = u.Host, u.Scheme == "http"
+	}
+	if v := env.BoolPtr("OTLP_INSECURE"); v != nil {
+		plaintext = *v
+	}
+
+	creds := insecure.NewCredentials()
+	if !plaintext {
+		creds = credentials.NewTLS(loadTLS(env, "OTLP"))
+	}
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		env.Errorf("%sOTLP_ENDPOINT %q invalid: %s", env.Prefix, o.endpoint, err.Error())
+		return
+	}
+	o.conn = conn
+	o.logs = collogspb.NewLogsServiceClient(conn)
+}
+
+func (o *otlp) Send(ctx context.Context, events []Event) error {
+	req := o.request(events)
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+
+	if o.protocol == otlpProtocolGRPC {
+		return o.sendGRPC(ctx, req)
+	}
+	return o.sendHTTP(ctx, req)
+}
+
+func (o *otlp) sendGRPC(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error {
+	if len(o.headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(o.headers))
+	}
+	var opts []grpc.CallOption
+	if o.gzip {
+		opts = append(opts, grpc.UseCompressor(grpcgzip.Name))
+	}
+
+	resp, err := o.logs.Export(ctx, req, opts...)
+	if err != nil {
+		// 按 OTLP 规范重试临时错误, 认证及地址错误修复配置后可以成功, 同样重试
+		switch status.Code(err) {
+		case codes.Canceled, codes.DeadlineExceeded, codes.Aborted, codes.OutOfRange,
+			codes.Unavailable, codes.DataLoss, codes.ResourceExhausted,
+			codes.Unauthenticated, codes.PermissionDenied, codes.NotFound, codes.Unimplemented:
+			return err
+		}
+		return Permanent(err)
+	}
+	o.partialSuccess(resp)
+	return nil
+}
+
+func (o *otlp) sendHTTP(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return Permanent(err)
+	}
+	if o.gzip {
+		body = gzipBytes(body)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	r.Header.Set("Content-Type", "application/x-protobuf")
+	if o.gzip {
+		r.Header.Set("Content-Encoding", "gzip")
+	}
+	for k, v := range o.headers {
+		r.Header.Set(k, v)
+	}
+	err = doHTTP(o.client, r)
+	// 400 为该批日志格式错误, 重试也不会成功
+	if status, _ := responseStatus(err); status == http.StatusBadRequest {
+		return Permanent(err)
+	}
+	return err
+}
+
+// partialSuccess 记录被 collector 拒绝的日志, 这部分重试也不会成功
+func (o *otlp) partialSuccess(resp *collogspb.ExportLogsServiceResponse) {
+	if ps := resp.GetPartialSuccess(); ps != nil && ps.GetRejectedLogRecords() > 0 {
+		logc.Errorf(context.Background(), "output %s rejected %d log records: %s", o.name, ps.GetRejectedLogRecords(), ps.GetErrorMessage())
+	}
+}
+
+// request 按资源属性分组生成 ExportLogsServiceRequest
+func (o *otlp) request(events []Event) *collogspb.ExportLogsServiceRequest {
+	req := &collogspb.ExportLogsServiceRequest{}
+	scopes := make(map[string]*logspb.ScopeLogs)
+	now := uint64(time.Now().UnixNano())
+	for _, ev := range events {
+		key := fieldsKey(ev.Fields)
+		scope, ok := scopes[key]
+		if !ok {
+			scope = &logspb.ScopeLogs{Scope: &commonpb.InstrumentationScope{Name: otlpScope}}
+			scopes[key] = scope
+			req.ResourceLogs = append(req.ResourceLogs, &logspb.ResourceLogs{
+				Resource:  &resourcepb.Resource{Attributes: o.resource(ev.Fields)},
+				ScopeLogs: []*logspb.ScopeLogs{scope},
+			})
+		}
+		scope.LogRecords = append(scope.LogRecords, otlpRecord(ev, now))
+	}
+	return req
+}
+
+// resource 容器字段及日志 tags 转换为资源属性
+func (o *otlp) resource(fields map[string]string) []*commonpb.KeyValue {
+	var keys []string
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	service := o.service
+	if service == "" {
+		service = fields["k8s_container_name"]
+	}
+	var attrs []*commonpb.KeyValue
+	if service != "" {
+		attrs = append(attrs, stringAttr("service.name", service))
+	}
+	for _, k := range keys {
+		name := k
+		if v, ok := otlpResourceKeys[k]; ok {
+			name = v
+		}
+		attrs = append(attrs, stringAttr(name, fields[k]))
+	}
+	return attrs
+}
+
+// otlpRecord 转换为 LogRecord, JSON 格式的日志提取 trace_id, span_id 及日志级别
+func otlpRecord(ev Event, observed uint64) *logspb.LogRecord {
+	r := &logspb.LogRecord{
+		ObservedTimeUnixNano: observed,
+		Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: ev.Message}},
+		Attributes: []*commonpb.KeyValue{
+			stringAttr("log.file.path", ev.Source),
+		},
+	}
+	if !ev.Timestamp.IsZero() {
+		r.TimeUnixNano = uint64(ev.Timestamp.UnixNano())
+	}
+	if ev.Stream != "" {
+		r.Attributes = append(r.Attributes, stringAttr("log.iostream", ev.Stream))
+	}
+
+	fields := jsonFields(ev.Message)
+	if fields == nil {
+		return r
+	}
+	if id := hexID(fields, 16, "trace_id", "traceId", "traceID", "trace.id"); id != nil {
+		r.TraceId = id
+	}
+	if id := hexID(fields, 8, "span_id", "spanId", "spanID", "span.id"); id != nil {
+		r.SpanId = id
+	}
+	for _, k := range []string{"level", "severity", "lvl"} {
+		if level, ok := fields[k].(string); ok && level != "" {
+			r.SeverityText = level
+			r.SeverityNumber = severityNumber(level)
+			break
+		}
+	}
+	return r
+}
+
+// jsonFields 解析 JSON 对象格式的日志, 其他格式返回 nil
+func jsonFields(message string) map[string]interface{} {
+	message = strings.TrimSpace(message)
+	if !strings.HasPrefix(message, "{") {
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(message), &fields); err != nil {
+		return nil
+	}
+	return fields
+}
+
+// hexID 读取十六进制的 id, 长度不符或全为 0 时忽略
+func hexID(fields map[string]interface{}, size int, keys ...string) []byte {
+	for _, k := range keys {
+		s, ok := fields[k].(string)
+		if !ok {
+			continue
+		}
+		id, err := hex.DecodeString(s)
+		if err != nil || len(id) != size || bytes.Equal(id, make([]byte, size)) {
+			continue
+		}
+		return id
+	}
+	return nil
+}
+
+func severityNumber(level string) logspb.SeverityNumber {
+	switch strings.ToLower(level) {
+	case "trace":
+		return logspb.SeverityNumber_SEVERITY_NUMBER_TRACE
+	case "debug":
+		return logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG
+	case "info", "information", "notice":
+		return logspb.SeverityNumber_SEVERITY_NUMBER_INFO
+	case "warn", "warning":
+		return logspb.SeverityNumber_SEVERITY_NUMBER_WARN
+	case "error", "err":
+		return logspb.SeverityNumber_SEVERITY_NUMBER_ERROR
+	case "fatal", "critical", "crit", "panic":
+		return logspb.SeverityNumber_SEVERITY_NUMBER_FATAL
+	}
+	return logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED
+}
+
+func stringAttr(k, v string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: k, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}}
+}
+
+// fieldsKey 字段的唯一标识, 相同字段的日志属于同一资源
+func fieldsKey(fields map[string]string) string {
+	var keys []string
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%q=%q,", k, fields[k])
+	}
+	return b.String()
+}
+
+func (o *otlp) Close() error {
+	if o.conn != nil {
+		return o.conn.Close()
+	}
+	if o.client != nil {
+		o.client.CloseIdleConnections()
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"watchlog/pkg/tools"
+)
+
+func otlpEvent(message string) Event {
+	return Event{
+		Timestamp: time.Unix(1700000000, 5),
+		Message:   message,
+		Stream:    "stderr",
+		Source:    "/var/log/pods/api.log",
+		Fields: map[string]string{
+			"k8s_pod_namespace":  "prod",
+			"k8s_pod":            "api-1",
+			"k8s_container_name": "api",
+			"k8s_node_name":      "node-1",
+			"team":               "pay",
+		},
+	}
+}
+
+// attrs 转换为 map 便于比较
+func attrs(kvs []*commonpb.KeyValue) map[string]string {
+	m := make(map[string]string)
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value.GetStringValue()
+	}
+	return m
+}
+
+func TestOTLPRecord(t *testing.T) {
+	trace := "4bf92f3577b34da6a3ce929d0e0e4736"
+	span := "00f067aa0ba902b7"
+	cases := []struct {
+		name     string
+		message  string
+		trace    string
+		span     string
+		text     string
+		severity logspb.SeverityNumber
+	}{
+		{"plain text", "GET /healthz 200", "", "", "", logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED},
+		{"snake case", `{"trace_id":"` + trace + `","span_id":"` + span + `","level":"info"}`, trace, span, "info", logspb.SeverityNumber_SEVERITY_NUMBER_INFO},
+		{"camel case", `{"traceId":"` + trace + `","spanId":"` + span + `","severity":"WARNING"}`, trace, span, "WARNING", logspb.SeverityNumber_SEVERITY_NUMBER_WARN},
+		{"dotted keys", `{"trace.id":"` + trace + `","span.id":"` + span + `","lvl":"err"}`, trace, span, "err", logspb.SeverityNumber_SEVERITY_NUMBER_ERROR},
+		// 长度不符, 全为 0 或不是十六进制的 id 忽略
+		{"short trace id", `{"trace_id":"4bf92f35","span_id":"` + span + `"}`, "", span, "", logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED},
+		{"zero ids", `{"trace_id":"00000000000000000000000000000000","span_id":"0000000000000000"}`, "", "", "", logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED},
+		{"not hex", `{"trace_id":"zzf92f3577b34da6a3ce929d0e0e4736","traceID":"` + trace + `"}`, trace, "", "", logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED},
+		{"numeric level", `{"level":30,"severity":"debug"}`, "", "", "debug", logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG},
+		{"trace level", `{"level":"TRACE"}`, "", "", "TRACE", logspb.SeverityNumber_SEVERITY_NUMBER_TRACE},
+		{"fatal level", `{"level":"panic"}`, "", "", "panic", logspb.SeverityNumber_SEVERITY_NUMBER_FATAL},
+		{"unknown level", `{"level":"verbose"}`, "", "", "verbose", logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED},
+		{"broken json", `{"trace_id":"` + trace, "", "", "", logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED},
+	}
+	for _, c := range cases {
+		r := otlpRecord(otlpEvent(c.message), 42)
+		if got := hex.EncodeToString(r.TraceId); got != c.trace {
+			t.Errorf("%s: trace id = %q, want %q", c.name, got, c.trace)
+		}
+		if got := hex.EncodeToString(r.SpanId); got != c.span {
+			t.Errorf("%s: span id = %q, want %q", c.name, got, c.span)
+		}
+		if r.SeverityText != c.text || r.SeverityNumber != c.severity {
+			t.Errorf("%s: severity = %q %v, want %q %v", c.name, r.SeverityText, r.SeverityNumber, c.text, c.severity)
+		}
+		if r.Body.GetStringValue() != c.message || r.TimeUnixNano != 1700000000000000005 || r.ObservedTimeUnixNano != 42 {
+			t.Errorf("%s: record = %v", c.name, r)
+		}
+		if want := map[string]string{"log.file.path": "/var/log/pods/api.log", "log.iostream": "stderr"}; !reflect.DeepEqual(attrs(r.Attributes), want) {
+			t.Errorf("%s: attributes = %v, want %v", c.name, attrs(r.Attributes), want)
+		}
+	}
+}
+
+func TestOTLPResource(t *testing.T) {
+	o := &otlp{}
+	other := otlpEvent("3")
+	other.Fields = map[string]string{"k8s_pod_namespace": "dev", "k8s_container_name": "worker"}
+	req := o.request([]Event{otlpEvent("1"), other, otlpEvent("2")})
+
+	// 相同字段的日志属于同一资源, 按首次出现的顺序
+	if len(req.ResourceLogs) != 2 {
+		t.Fatalf("got %d resources, want 2", len(req.ResourceLogs))
+	}
+	api, worker := req.ResourceLogs[0], req.ResourceLogs[1]
+	want := map[string]string{
+		"service.name":       "api",
+		"k8s.namespace.name": "prod",
+		"k8s.pod.name":       "api-1",
+		"k8s.container.name": "api",
+		"k8s.node.name":      "node-1",
+		"team":               "pay",
+	}
+	if got := attrs(api.Resource.Attributes); !reflect.DeepEqual(got, want) {
+		t.Errorf("resource attributes = %v, want %v", got, want)
+	}
+	if n := len(api.ScopeLogs[0].LogRecords); n != 2 || api.ScopeLogs[0].Scope.Name != otlpScope {
+		t.Errorf("api scope %q has %d records, want %s with 2", api.ScopeLogs[0].Scope.Name, n, otlpScope)
+	}
+	if got := attrs(worker.Resource.Attributes)["service.name"]; got != "worker" {
+		t.Errorf("worker service.name = %q", got)
+	}
+
+	// OTLP_SERVICE_NAME 优先于容器名称
+	o.service = "checkout"
+	if got := attrs(o.request([]Event{otlpEvent("1")}).ResourceLogs[0].Resource.Attributes)["service.name"]; got != "checkout" {
+		t.Errorf("service.name = %q, want checkout", got)
+	}
+}
+
+// logsServer 记录收到的请求及请求头, errs 依次作为响应, 用完后成功
+type logsServer struct {
+	collogspb.UnimplementedLogsServiceServer
+	mu       sync.Mutex
+	errs     []error
+	requests []*collogspb.ExportLogsServiceRequest
+	md       []metadata.MD
+}
+
+func (s *logsServer) Export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.requests = append(s.requests, req)
+	s.md = append(s.md, md)
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return nil, err
+	}
+	return &collogspb.ExportLogsServiceResponse{}, nil
+}
+
+// newGRPCSink 创建 grpc 协议的输出, 连接替换为 bufconn 上的 LogsService
+func newGRPCSink(t *testing.T, srv *logsServer, envs map[string]string) *otlp {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	collogspb.RegisterLogsServiceServer(s, srv)
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(s.Stop)
+
+	envs["OTLP_ENDPOINT"] = "bufnet:4317"
+	envs["OTLP_INSECURE"] = "true"
+	o := newTestSink(t, "otlp", "grpc", envs).(*otlp)
+	_ = o.conn.Close()
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.conn, o.logs = conn, collogspb.NewLogsServiceClient(conn)
+	return o
+}
+
+func TestOTLPGRPC(t *testing.T) {
+	srv := &logsServer{}
+	o := newGRPCSink(t, srv, map[string]string{
+		"OTLP_HEADERS":     "authorization=Bearer abc",
+		"OTLP_COMPRESSION": "gzip",
+	})
+	if o.protocol != otlpProtocolGRPC {
+		t.Fatalf("protocol = %q, want grpc by default", o.protocol)
+	}
+	if err := o.Send(context.Background(), []Event{otlpEvent("hello")}); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.requests) != 1 {
+		t.Fatalf("server got %d requests, want 1", len(srv.requests))
+	}
+	if got := srv.md[0].Get("authorization"); !reflect.DeepEqual(got, []string{"Bearer abc"}) {
+		t.Errorf("authorization metadata = %v", got)
+	}
+	// gzip 压缩的请求由服务端解压
+	r := srv.requests[0].ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+	if r.Body.GetStringValue() != "hello" {
+		t.Errorf("record body = %q", r.Body.GetStringValue())
+	}
+}
+
+func TestOTLPGRPCRetry(t *testing.T) {
+	cases := []struct {
+		code      codes.Code
+		permanent bool
+	}{
+		{codes.Unavailable, false},
+		{codes.ResourceExhausted, false},
+		{codes.DeadlineExceeded, false},
+		{codes.Unauthenticated, false},
+		{codes.PermissionDenied, false},
+		{codes.NotFound, false},
+		{codes.Unimplemented, false},
+		{codes.InvalidArgument, true},
+		{codes.Internal, true},
+	}
+	for _, c := range cases {
+		srv := &logsServer{errs: []error{status.Error(c.code, "test")}}
+		o := newGRPCSink(t, srv, map[string]string{})
+		err := o.Send(context.Background(), []Event{otlpEvent("hello")})
+		var permanent *permanentError
+		if err == nil || errors.As(err, &permanent) != c.permanent {
+			t.Errorf("%s: Send = %v, want permanent %v", c.code, err, c.permanent)
+		}
+		// 重试成功
+		if !c.permanent {
+			if err := o.Send(context.Background(), []Event{otlpEvent("hello")}); err != nil {
+				t.Errorf("%s: Send after recovery = %v", c.code, err)
+			}
+		}
+	}
+}
+
+func TestOTLPHTTP(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []*http.Request
+		bodies   [][]byte
+		statuses = []int{http.StatusServiceUnavailable, http.StatusUnauthorized, http.StatusBadRequest}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+		status := http.StatusOK
+		if len(statuses) > 0 {
+			status, statuses = statuses[0], statuses[1:]
+		}
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	o := newTestSink(t, "otlp", "http", map[string]string{
+		"OTLP_PROTOCOL":    otlpProtocolHTTP,
+		"OTLP_ENDPOINT":    srv.URL,
+		"OTLP_HEADERS":     "x-api-key=k1",
+		"OTLP_COMPRESSION": "gzip",
+	}).(*otlp)
+	events := []Event{otlpEvent(`{"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736","level":"error"}`)}
+
+	// 503 及 401 重试, 400 为该批日志格式错误
+	var permanent *permanentError
+	for _, want := range []bool{false, false, true} {
+		err := o.Send(context.Background(), events)
+		if err == nil || errors.As(err, &permanent) != want {
+			t.Errorf("Send = %v, want permanent %v", err, want)
+		}
+	}
+	if err := o.Send(context.Background(), events); err != nil {
+		t.Fatalf("Send after recovery = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	r, body := requests[len(requests)-1], bodies[len(bodies)-1]
+	// 未指定路径时使用 /v1/logs
+	if r.URL.Path != otlpLogsPath || r.Header.Get("Content-Type") != "application/x-protobuf" || r.Header.Get("x-api-key") != "k1" {
+		t.Errorf("request %s with headers %v", r.URL.Path, r.Header)
+	}
+	if r.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", r.Header.Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(zr)
+	var req collogspb.ExportLogsServiceRequest
+	if err := proto.Unmarshal(data, &req); err != nil {
+		t.Fatal(err)
+	}
+	rec := req.ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+	if hex.EncodeToString(rec.TraceId) != "4bf92f3577b34da6a3ce929d0e0e4736" || rec.SeverityNumber != logspb.SeverityNumber_SEVERITY_NUMBER_ERROR {
+		t.Errorf("record = %v", rec)
+	}
+}
+
+func TestOTLPConfig(t *testing.T) {
+	cases := []struct {
+		envs map[string]string
+		ok   bool
+	}{
+		{map[string]string{"OTLP_ENDPOINT": "collector:4317"}, true},
+		{map[string]string{"OTLP_ENDPOINT": "http://collector:4317"}, true},
+		{map[string]string{"OTLP_PROTOCOL": otlpProtocolHTTP, "OTLP_ENDPOINT": "https://collector:4318/custom"}, true},
+		{map[string]string{"OTLP_PROTOCOL": otlpProtocolHTTP, "OTLP_ENDPOINT": "collector:4318"}, false},
+		{map[string]string{"OTLP_PROTOCOL": "thrift", "OTLP_ENDPOINT": "collector:4317"}, false},
+		{map[string]string{"OTLP_ENDPOINT": "collector:4317", "OTLP_COMPRESSION": "zstd"}, false},
+		{map[string]string{}, false},
+	}
+	for i, c := range cases {
+		name := "CFG" + string(rune('A'+i))
+		for k, v := range c.envs {
+			t.Setenv("TEST_"+name+"_"+k, v)
+		}
+		sink, err := NewSink("otlp", name, tools.NewEnv("TEST_"+name+"_"))
+		if (err == nil) != c.ok {
+			t.Errorf("%v: NewSink = %v, want ok %v", c.envs, err, c.ok)
+		}
+		if sink != nil {
+			_ = sink.Close()
+		}
+	}
+
+	// 指定路径时保留
+	o := newTestSink(t, "otlp", "path", map[string]string{"OTLP_PROTOCOL": otlpProtocolHTTP, "OTLP_ENDPOINT": "https://collector:4318/custom"}).(*otlp)
+	if o.endpoint != "https://collector:4318/custom" {
+		t.Errorf("endpoint = %q", o.endpoint)
+	}
+}
@@ -0,0 +1,61 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// line 解析后的容器日志行
+type line struct {
+	timestamp time.Time
+	stream    string
+	partial   bool
+	content   []byte
+}
+
+type dockerLine struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+// parseLine 解析 docker json-file 或 CRI 格式的容器日志行
+//
+//	{"log":"message\n","stream":"stdout","time":"2024-01-01T00:00:00.000000000Z"}
+//	2024-01-01T00:00:00.000000000Z stdout F message
+func parseLine(raw []byte) (line, error) {
+	if len(raw) > 0 && raw[0] == '{' {
+		var l dockerLine
+		if err := json.Unmarshal(raw, &l); err != nil {
+			return line{}, fmt.Errorf("invalid docker log line: %s", err.Error())
+		}
+		content := []byte(l.Log)
+		partial := !bytes.HasSuffix(content, []byte("\n"))
+		return line{
+			timestamp: l.Time,
+			stream:    l.Stream,
+			partial:   partial,
+			content:   bytes.TrimSuffix(content, []byte("\n")),
+		}, nil
+	}
+
+	fields := bytes.SplitN(raw, []byte(" "), 4)
+	if len(fields) < 3 {
+		return line{}, fmt.Errorf("invalid cri log line: %q", raw)
+	}
+	ts, err := time.Parse(time.RFC3339Nano, string(fields[0]))
+	if err != nil {
+		return line{}, fmt.Errorf("invalid cri log time: %s", err.Error())
+	}
+	l := line{
+		timestamp: ts,
+		stream:    string(fields[1]),
+		partial:   string(fields[2]) == "P",
+	}
+	if len(fields) == 4 {
+		l.content = fields[3]
+	}
+	return l, nil
+}
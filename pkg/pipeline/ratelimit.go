@@ -0,0 +1,83 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	logtypes "watchlog/log/config"
+	"watchlog/pkg/metrics"
+)
+
+// bucket 令牌桶, 容量为一秒的令牌数, rate 为 0 时不限制
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) fill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+}
+
+// enough 令牌足够或桶已满时返回 true, 桶满时允许超过容量的单个事件通过
+func (b *bucket) enough(n float64) bool {
+	return b.rate == 0 || b.tokens >= n || b.tokens >= b.rate
+}
+
+func (b *bucket) take(n float64) {
+	if b.rate == 0 {
+		return
+	}
+	b.tokens -= n
+}
+
+// rateLimiter 一个容器日志的限速, 同一日志的全部文件共用
+type rateLimiter struct {
+	mu     sync.Mutex
+	events bucket
+	bytes  bucket
+	// 丢弃的事件及字节数
+	droppedEvents prometheus.Counter
+	droppedBytes  prometheus.Counter
+}
+
+// newRateLimiter 日志未设置限速时返回 nil
+func newRateLimiter(r *logtypes.RateLimit, namespace, container, log string) *rateLimiter {
+	if r == nil || (r.Events == 0 && r.Bytes == 0) {
+		return nil
+	}
+	now := time.Now()
+	return &rateLimiter{
+		events:        bucket{rate: r.Events, tokens: r.Events, last: now},
+		bytes:         bucket{rate: float64(r.Bytes), tokens: float64(r.Bytes), last: now},
+		droppedEvents: metrics.RateLimitedEvents.WithLabelValues(namespace, container, log),
+		droppedBytes:  metrics.RateLimitedBytes.WithLabelValues(namespace, container, log),
+	}
+}
+
+// allow 判断 size 字节的事件能否通过, 不能通过时计入丢弃
+func (l *rateLimiter) allow(size int) bool {
+	return l.allowAt(size, time.Now())
+}
+
+// allowAt 按 now 补充令牌后判断, 测试时传入指定的时间
+func (l *rateLimiter) allowAt(size int, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events.fill(now)
+	l.bytes.fill(now)
+	if !l.events.enough(1) || !l.bytes.enough(float64(size)) {
+		l.droppedEvents.Inc()
+		l.droppedBytes.Add(float64(size))
+		return false
+	}
+	l.events.take(1)
+	l.bytes.take(float64(size))
+	return true
+}
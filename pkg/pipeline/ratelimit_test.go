@@ -0,0 +1,122 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	logtypes "watchlog/log/config"
+)
+
+func TestNewRateLimiter(t *testing.T) {
+	if l := newRateLimiter(nil, "ns", "c1", "app"); l != nil {
+		t.Errorf("nil rate limit should not create a limiter")
+	}
+	if l := newRateLimiter(&logtypes.RateLimit{}, "ns", "c1", "app"); l != nil {
+		t.Errorf("zero rate limit should not create a limiter")
+	}
+	if l := newRateLimiter(&logtypes.RateLimit{Events: 1}, "ns", "c1", "app"); l == nil {
+		t.Errorf("event rate limit should create a limiter")
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	type step struct {
+		// after 距离创建限速的时间
+		after time.Duration
+		size  int
+		allow bool
+	}
+	cases := []struct {
+		name  string
+		limit logtypes.RateLimit
+		steps []step
+	}{
+		{
+			name:  "events",
+			limit: logtypes.RateLimit{Events: 2},
+			steps: []step{
+				{0, 10, true},
+				{0, 10, true},
+				{0, 10, false},
+				{500 * time.Millisecond, 10, true},
+				{500 * time.Millisecond, 10, false},
+				// 空闲后令牌最多补充到一秒的数量
+				{10 * time.Second, 10, true},
+				{10 * time.Second, 10, true},
+				{10 * time.Second, 10, false},
+			},
+		},
+		{
+			// 低于 1/s 时桶满即可通过一个事件, 0.5/s 每两秒一个
+			name:  "events below one per second",
+			limit: logtypes.RateLimit{Events: 0.5},
+			steps: []step{
+				{0, 10, true},
+				{time.Second, 10, false},
+				{1999 * time.Millisecond, 10, false},
+				{2 * time.Second, 10, true},
+				{3 * time.Second, 10, false},
+				{4 * time.Second, 10, true},
+			},
+		},
+		{
+			name:  "bytes",
+			limit: logtypes.RateLimit{Bytes: 100},
+			steps: []step{
+				{0, 60, true},
+				{0, 60, false},
+				{0, 40, true},
+				{0, 1, false},
+				{100 * time.Millisecond, 10, true},
+			},
+		},
+		{
+			// 超过字节数限速的单个事件在桶满时通过, 之后等待令牌补回
+			name:  "event larger than byte rate",
+			limit: logtypes.RateLimit{Bytes: 100},
+			steps: []step{
+				{0, 250, true},
+				{time.Second, 10, false},
+				{2 * time.Second, 60, false},
+				{2 * time.Second, 50, true},
+				{2500 * time.Millisecond, 60, false},
+				{3 * time.Second, 250, true},
+				{4 * time.Second, 1, false},
+			},
+		},
+		{
+			name:  "events and bytes",
+			limit: logtypes.RateLimit{Events: 10, Bytes: 100},
+			steps: []step{
+				{0, 90, true},
+				// 事件数足够, 字节数不足
+				{0, 20, false},
+				{0, 10, true},
+				{time.Second, 1, true},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			l := newRateLimiter(&c.limit, "ratelimit-test", c.name, "app")
+			start := l.events.last
+			var droppedEvents, droppedBytes float64
+			for i, s := range c.steps {
+				if got := l.allowAt(s.size, start.Add(s.after)); got != s.allow {
+					t.Fatalf("step %d: allowAt(%d, +%s) = %v, want %v", i, s.size, s.after, got, s.allow)
+				}
+				if !s.allow {
+					droppedEvents++
+					droppedBytes += float64(s.size)
+				}
+			}
+			if got := testutil.ToFloat64(l.droppedEvents); got != droppedEvents {
+				t.Errorf("dropped events = %v, want %v", got, droppedEvents)
+			}
+			if got := testutil.ToFloat64(l.droppedBytes); got != droppedBytes {
+				t.Errorf("dropped bytes = %v, want %v", got, droppedBytes)
+			}
+		})
+	}
+}
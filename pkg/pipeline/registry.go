@@ -0,0 +1,130 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logc"
+)
+
+// FileState 原生采集的文件进度
+type FileState struct {
+	Source  string    `json:"source"`
+	Offset  int64     `json:"offset"`
+	Updated time.Time `json:"updated"`
+}
+
+// Registry 记录原生采集的文件进度, 以设备号及 inode 作为键, 文件轮转后仍能继续采集
+type Registry struct {
+	path   string
+	mu     sync.Mutex
+	dirty  bool
+	states map[string]FileState
+}
+
+func NewRegistry(path string) *Registry {
+	return &Registry{path: path, states: make(map[string]FileState)}
+}
+
+// fileKey returns device-inode of the file
+func fileKey(info os.FileInfo) string {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprintf("%d-%d", stat.Dev, stat.Ino)
+	}
+	return info.Name()
+}
+
+// Load 读取上次保存的进度
+func (r *Registry) Load() error {
+	data, err := ioutil.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return json.Unmarshal(data, &r.states)
+}
+
+func (r *Registry) Get(key string) (FileState, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state, ok := r.states[key]
+	return state, ok
+}
+
+func (r *Registry) Set(key string, state FileState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state.Updated = time.Now()
+	r.states[key] = state
+	r.dirty = true
+}
+
+// States returns a copy of all file states
+func (r *Registry) States() map[string]FileState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	states := make(map[string]FileState, len(r.states))
+	for k, v := range r.states {
+		states[k] = v
+	}
+	return states
+}
+
+// Save 先写临时文件再重命名, 避免进度文件损坏
+func (r *Registry) Save() error {
+	r.mu.Lock()
+	if !r.dirty {
+		r.mu.Unlock()
+		return nil
+	}
+	// 清理已经删除的文件
+	for key, state := range r.states {
+		if _, err := os.Stat(state.Source); os.IsNotExist(err) && time.Since(state.Updated) > time.Hour {
+			delete(r.states, key)
+		}
+	}
+	data, err := json.Marshal(r.states)
+	r.dirty = false
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// flush 定期保存进度
+func (r *Registry) flush(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			_ = r.Save()
+			return
+		case <-ticker.C:
+			if err := r.Save(); err != nil {
+				logc.Errorf(context.Background(), "save native registry failed: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logc"
+	"watchlog/pkg/tools"
+)
+
+// Batch 原生输出的批量发送配置
+type Batch struct {
+	// Size 单批最大事件数
+	Size int
+	// Wait 未达到 Size 时最长等待时间
+	Wait time.Duration
+	// MaxBackoff 发送失败后重试的最大间隔
+	MaxBackoff time.Duration
+	// Spool 输出不可用时暂存事件到磁盘, 采集不会暂停
+	Spool bool
+	// SpoolDir 暂存目录, 为空时由调用方指定
+	SpoolDir string
+	// SpoolMaxBytes 暂存上限, 超出时丢弃最早的批次
+	SpoolMaxBytes int64
+}
+
+// LoadBatch 读取 BATCH_SIZE, BATCH_WAIT, MAX_BACKOFF, SPOOL_ENABLED, SPOOL_DIR, SPOOL_MAX_SIZE
+func LoadBatch(env *tools.Env) Batch {
+	b := Batch{Size: 1024, Wait: time.Second, MaxBackoff: 30 * time.Second, SpoolMaxBytes: 1 << 30}
+	if size := env.Int("BATCH_SIZE"); size > 0 {
+		b.Size = size
+	}
+	if wait := env.Duration("BATCH_WAIT"); wait != "" {
+		b.Wait, _ = time.ParseDuration(wait)
+	}
+	if backoff := env.Duration("MAX_BACKOFF"); backoff != "" {
+		b.MaxBackoff, _ = time.ParseDuration(backoff)
+	}
+	if spool := env.BoolPtr("SPOOL_ENABLED"); spool != nil {
+		b.Spool = *spool
+	}
+	b.SpoolDir = env.Str("SPOOL_DIR")
+	if size := env.Size("SPOOL_MAX_SIZE"); size != "" {
+		b.SpoolMaxBytes, _ = tools.ParseSize(size)
+	}
+	return b
+}
+
+// permanentError 重试无法成功的错误, 例如请求格式错误, 该批事件被丢弃
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Permanent marks err as not retryable
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// request 等待发送的事件, 发送完成后通过 done 确认
+type request struct {
+	events []Event
+	done   chan error
+}
+
+// output 一个原生输出的发送队列
+type output struct {
+	name  string
+	sink  Sink
+	batch Batch
+	queue chan *request
+	spool *spool
+	// mu 保证 sink 不会被并发调用
+	mu sync.Mutex
+}
+
+// Router 将事件分发到日志选择的每个原生输出
+type Router struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	outputs map[string]*output
+}
+
+func NewRouter() *Router {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Router{ctx: ctx, cancel: cancel, outputs: make(map[string]*output)}
+}
+
+// Add register the sink of a named output and starts its batching worker
+func (r *Router) Add(name string, sink Sink, batch Batch) error {
+	o := &output{
+		name:  name,
+		sink:  sink,
+		batch: batch,
+		queue: make(chan *request, 64),
+	}
+	if batch.Spool {
+		s, err := openSpool(batch.SpoolDir, batch.SpoolMaxBytes)
+		if err != nil {
+			return fmt.Errorf("open spool of output %s failed, err: %s", name, err.Error())
+		}
+		o.spool = s
+		go o.drain(r.ctx)
+	}
+	r.outputs[name] = o
+	go o.run(r.ctx)
+	return nil
+}
+
+// Has returns whether the named output is a native output
+func (r *Router) Has(name string) bool {
+	_, ok := r.outputs[name]
+	return ok
+}
+
+// Outputs returns the names of all native outputs
+func (r *Router) Outputs() []string {
+	var names []string
+	for name := range r.outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Buffers returns the spool stats of native outputs with spool enabled
+func (r *Router) Buffers() []BufferStats {
+	var stats []BufferStats
+	for _, name := range r.Outputs() {
+		o := r.outputs[name]
+		if o.spool == nil {
+			continue
+		}
+		s := o.spool.Stats()
+		s.Output = name
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// Route 发送事件到 outputs 中的原生输出并等待全部输出确认, 非原生输出由采集器处理
+//
+// 输出不可用时 Route 一直阻塞, 采集进度不会前进, 开启暂存的输出写入磁盘后即确认. 只有 ctx 结束时返回错误.
+func (r *Router) Route(ctx context.Context, outputs []string, events []Event) error {
+	var reqs []*request
+	for _, name := range outputs {
+		o, ok := r.outputs[name]
+		if !ok {
+			continue
+		}
+		req := &request{events: events, done: make(chan error, 1)}
+		select {
+		case o.queue <- req:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		reqs = append(reqs, req)
+	}
+
+	for _, req := range reqs {
+		select {
+		case err := <-req.done:
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Close stops the workers and closes all sinks
+func (r *Router) Close() {
+	r.cancel()
+	for _, o := range r.outputs {
+		_ = o.sink.Close()
+	}
+}
+
+// run 合并多个请求为一批发送
+func (o *output) run(ctx context.Context) {
+	for {
+		var (
+			reqs []*request
+			n    int
+		)
+		select {
+		case req := <-o.queue:
+			reqs, n = append(reqs, req), len(req.events)
+		case <-ctx.Done():
+			return
+		}
+
+		timer := time.NewTimer(o.batch.Wait)
+	collect:
+		for n < o.batch.Size {
+			select {
+			case req := <-o.queue:
+				reqs, n = append(reqs, req), n+len(req.events)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		events := make([]Event, 0, n)
+		for _, req := range reqs {
+			events = append(events, req.events...)
+		}
+		var err error
+		if o.spool != nil {
+			err = o.spoolOrSend(ctx, events)
+		} else {
+			err = o.send(ctx, events)
+		}
+		for _, req := range reqs {
+			req.done <- err
+		}
+	}
+}
+
+// deliver 发送一次, 返回 false 表示需要重试
+func (o *output) deliver(ctx context.Context, events []Event) (bool, error) {
+	o.mu.Lock()
+	err := o.sink.Send(ctx, events)
+	o.mu.Unlock()
+	if err == nil {
+		return true, nil
+	}
+
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		logc.Errorf(context.Background(), "output %s dropped %d events: %v", o.name, len(events), err)
+		return true, nil
+	}
+	return false, err
+}
+
+// send 按指数退避重试直到发送成功, 不可重试的错误丢弃该批事件
+func (o *output) send(ctx context.Context, events []Event) error {
+	backoff := time.Second
+	for {
+		ok, err := o.deliver(ctx, events)
+		if ok {
+			return nil
+		}
+
+		logc.Errorf(context.Background(), "output %s send %d events failed, retry in %s: %v", o.name, len(events), backoff, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("output %s stopped: %w", o.name, ctx.Err())
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > o.batch.MaxBackoff {
+			backoff = o.batch.MaxBackoff
+		}
+	}
+}
+
+// spoolOrSend 发送失败或暂存中还有事件时写入暂存, 保证发送顺序
+func (o *output) spoolOrSend(ctx context.Context, events []Event) error {
+	if o.spool.Len() == 0 {
+		ok, err := o.deliver(ctx, events)
+		if ok {
+			return nil
+		}
+		logc.Errorf(context.Background(), "output %s send %d events failed, spooled: %v", o.name, len(events), err)
+	}
+	if err := o.spool.Put(events); err != nil {
+		// 磁盘不可用时退回到阻塞重试
+		logc.Errorf(context.Background(), "output %s spool failed: %v", o.name, err)
+		return o.send(ctx, events)
+	}
+	return nil
+}
+
+// drain 按写入顺序发送暂存的事件
+func (o *output) drain(ctx context.Context) {
+	for {
+		name, events := o.spool.Peek()
+		if events == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-o.spool.notify:
+			}
+			continue
+		}
+		if err := o.send(ctx, events); err != nil {
+			return
+		}
+		o.spool.Remove(name)
+	}
+}
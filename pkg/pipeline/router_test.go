@@ -0,0 +1,172 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSink 记录收到的事件, 前 fail 次发送返回 err
+type fakeSink struct {
+	mu     sync.Mutex
+	fail   int
+	err    error
+	calls  int
+	events []Event
+}
+
+func (s *fakeSink) Send(ctx context.Context, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.calls <= s.fail {
+		return s.err
+	}
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *fakeSink) Close() error {
+	return nil
+}
+
+func (s *fakeSink) sent() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func (s *fakeSink) received() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+func testEvents(messages ...string) []Event {
+	var events []Event
+	for i, m := range messages {
+		events = append(events, Event{Message: m, Offset: int64(i + 1)})
+	}
+	return events
+}
+
+func TestOutputDeliver(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		ok     bool
+		hasErr bool
+		sent   int
+	}{
+		{"success", nil, true, false, 1},
+		{"retryable", errors.New("connection refused"), false, true, 0},
+		{"permanent", Permanent(errors.New("bad payload")), true, false, 0},
+		{"wrapped permanent", errors.Join(errors.New("batch"), Permanent(errors.New("bad payload"))), true, false, 0},
+	}
+	for _, c := range cases {
+		sink := &fakeSink{fail: 1, err: c.err}
+		if c.err == nil {
+			sink.fail = 0
+		}
+		o := &output{name: "test", sink: sink}
+		ok, err := o.deliver(context.Background(), testEvents("a"))
+		if ok != c.ok || (err != nil) != c.hasErr {
+			t.Errorf("%s: deliver = %v, %v, want %v, error %v", c.name, ok, err, c.ok, c.hasErr)
+		}
+		if got := len(sink.received()); got != c.sent {
+			t.Errorf("%s: sink received %d events, want %d", c.name, got, c.sent)
+		}
+	}
+}
+
+func TestRouterFanOut(t *testing.T) {
+	r := NewRouter()
+	defer r.Close()
+	a, b := &fakeSink{}, &fakeSink{}
+	batch := Batch{Size: 10, Wait: 10 * time.Millisecond, MaxBackoff: time.Second}
+	if err := r.Add("a", a, batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Add("b", b, batch); err != nil {
+		t.Fatal(err)
+	}
+
+	// default 不是原生输出, 由采集器发送
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.Route(ctx, []string{"a", "default"}, testEvents("1", "2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Route(ctx, []string{"a", "b"}, testEvents("3")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(a.received()); got != 3 {
+		t.Errorf("output a received %d events, want 3", got)
+	}
+	if got := b.received(); len(got) != 1 || got[0].Message != "3" {
+		t.Errorf("output b received %v, want [3]", got)
+	}
+}
+
+func TestRouterRetry(t *testing.T) {
+	r := NewRouter()
+	defer r.Close()
+	sink := &fakeSink{fail: 1, err: errors.New("503 unavailable")}
+	if err := r.Add("a", sink, Batch{Size: 10, Wait: 10 * time.Millisecond, MaxBackoff: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Route 在输出恢复前阻塞, 恢复后确认
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.Route(ctx, []string{"a"}, testEvents("1")); err != nil {
+		t.Fatal(err)
+	}
+	if sink.sent() != 2 || len(sink.received()) != 1 {
+		t.Errorf("sink calls = %d, received %d, want 2 calls and 1 event", sink.sent(), len(sink.received()))
+	}
+
+	// ctx 结束时 Route 返回错误, 采集进度不前进
+	down := &fakeSink{fail: 1 << 30, err: errors.New("503 unavailable")}
+	if err := r.Add("b", down, Batch{Size: 10, Wait: 10 * time.Millisecond, MaxBackoff: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := r.Route(ctx, []string{"b"}, testEvents("1")); err == nil {
+		t.Error("Route to an unavailable output should fail when ctx is done")
+	}
+}
+
+func TestRouterSpool(t *testing.T) {
+	r := NewRouter()
+	defer r.Close()
+	sink := &fakeSink{fail: 1, err: errors.New("503 unavailable")}
+	batch := Batch{Size: 10, Wait: 10 * time.Millisecond, MaxBackoff: time.Second, Spool: true, SpoolDir: t.TempDir()}
+	if err := r.Add("a", sink, batch); err != nil {
+		t.Fatal(err)
+	}
+
+	// 发送失败的批次写入暂存后立即确认
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := r.Route(ctx, []string{"a"}, testEvents("1", "2")); err != nil {
+		t.Fatal(err)
+	}
+	if stats := r.Buffers(); len(stats) != 1 || stats[0].Output != "a" {
+		t.Fatalf("Buffers = %+v, want spool of output a", stats)
+	}
+
+	// 输出恢复后按顺序发送暂存的事件
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.received()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	got := sink.received()
+	if len(got) != 2 || got[0].Message != "1" || got[1].Message != "2" {
+		t.Errorf("sink received %v, want [1 2]", got)
+	}
+}
@@ -0,0 +1,57 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"watchlog/pkg/tools"
+)
+
+// Event 原生采集的一条日志
+type Event struct {
+	Timestamp time.Time         `json:"@timestamp"`
+	Message   string            `json:"message"`
+	Stream    string            `json:"stream,omitempty"`
+	Source    string            `json:"source"`
+	Offset    int64             `json:"offset"`
+	Fields    map[string]string `json:"fields"`
+}
+
+// Sink 原生输出, 由 Router 调用
+type Sink interface {
+	// Send 发送一批事件, 返回错误时整批重试
+	Send(ctx context.Context, events []Event) error
+	Close() error
+}
+
+// SinkFactory creates a sink from the envs of an output, e.g. env.Str("LOKI_URL") reads AUDIT_LOKI_URL for the audit output
+type SinkFactory func(name string, env *tools.Env) (Sink, error)
+
+var sinks = make(map[string]SinkFactory)
+
+// RegisterSink register native output by LOGGING_OUTPUT type
+func RegisterSink(typ string, factory SinkFactory) {
+	sinks[typ] = factory
+}
+
+// IsNative returns whether the output type is served by the native pipeline instead of Filebeat
+func IsNative(typ string) bool {
+	_, ok := sinks[typ]
+	return ok
+}
+
+// NewSink creates the native output of type for the named output
+func NewSink(typ, name string, env *tools.Env) (Sink, error) {
+	factory, ok := sinks[typ]
+	if !ok {
+		return nil, fmt.Errorf("unsupported native output: %s", typ)
+	}
+	sink, err := factory(name, env)
+	if err != nil {
+		return nil, err
+	}
+	if err := env.Err(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
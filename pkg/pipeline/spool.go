@@ -0,0 +1,238 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logc"
+)
+
+const spoolExt = ".ndjson"
+
+// BufferStats 输出暂存的日志, 用于判断节点的积压程度
+type BufferStats struct {
+	Output string `json:"output"`
+	// Type native 为原生输出的暂存, filebeat 为采集器的磁盘队列
+	Type    string `json:"type"`
+	Path    string `json:"path"`
+	Bytes   int64  `json:"bytes"`
+	Batches int    `json:"batches"`
+	// MaxBytes 暂存上限, 超出时丢弃最早的批次
+	MaxBytes int64      `json:"max_bytes,omitempty"`
+	Oldest   *time.Time `json:"oldest,omitempty"`
+	// AgeSeconds 最早一批暂存至今的时间
+	AgeSeconds float64 `json:"age_seconds"`
+	// Evicted 超出上限被丢弃的日志条数
+	Evicted uint64 `json:"evicted"`
+}
+
+// spoolFile 一批暂存的事件, 文件名为 {序号}-{事件数}.ndjson
+type spoolFile struct {
+	name    string
+	size    int64
+	events  int
+	created time.Time
+}
+
+// spool 输出不可用时暂存事件的磁盘队列, 每批事件一个文件, 按写入顺序发送
+type spool struct {
+	dir      string
+	maxBytes int64
+	mu       sync.Mutex
+	files    []spoolFile
+	bytes    int64
+	seq      uint64
+	evicted  uint64
+	notify   chan struct{}
+}
+
+// openSpool 打开目录并加载上次未发送的批次, maxBytes 为 0 时不限制大小
+func openSpool(dir string, maxBytes int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &spool{dir: dir, maxBytes: maxBytes, notify: make(chan struct{}, 1)}
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if e.IsDir() || !strings.HasSuffix(name, spoolExt) {
+			continue
+		}
+		seqStr, countStr, _ := strings.Cut(strings.TrimSuffix(name, spoolExt), "-")
+		seq, err := strconv.ParseUint(seqStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		count, _ := strconv.Atoi(countStr)
+		s.files = append(s.files, spoolFile{name: name, size: e.Size(), events: count, created: e.ModTime()})
+		s.bytes += e.Size()
+		if seq > s.seq {
+			s.seq = seq
+		}
+	}
+	sort.Slice(s.files, func(i, j int) bool {
+		return s.files[i].name < s.files[j].name
+	})
+	return s, nil
+}
+
+// Len returns the number of spooled batches
+func (s *spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.files)
+}
+
+// Stats returns the spooled bytes, batches and age
+func (s *spool) Stats() BufferStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := BufferStats{
+		Type:     "native",
+		Path:     s.dir,
+		Bytes:    s.bytes,
+		Batches:  len(s.files),
+		MaxBytes: s.maxBytes,
+		Evicted:  s.evicted,
+	}
+	if len(s.files) > 0 {
+		oldest := s.files[0].created
+		stats.Oldest = &oldest
+		stats.AgeSeconds = time.Since(oldest).Seconds()
+	}
+	return stats
+}
+
+// Put 写入一批事件, 先写临时文件再重命名, 进程退出时不会留下不完整的批次
+func (s *spool) Put(events []Event) error {
+	data, err := encodeNDJSON(events)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.seq++
+	name := fmt.Sprintf("%020d-%d%s", s.seq, len(events), spoolExt)
+	s.mu.Unlock()
+
+	path := filepath.Join(s.dir, name)
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.files = append(s.files, spoolFile{name: name, size: int64(len(data)), events: len(events), created: time.Now()})
+	s.bytes += int64(len(data))
+	s.evict()
+	s.mu.Unlock()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// evict 超出上限时丢弃最早的批次, 至少保留最新的一批
+func (s *spool) evict() {
+	var dropped int
+	for s.maxBytes > 0 && s.bytes > s.maxBytes && len(s.files) > 1 {
+		f := s.files[0]
+		s.files = s.files[1:]
+		s.bytes -= f.size
+		s.evicted += uint64(f.events)
+		dropped += f.events
+		_ = os.Remove(filepath.Join(s.dir, f.name))
+	}
+	if dropped > 0 {
+		logc.Errorf(context.Background(), "spool %s exceeds %d bytes, evicted %d oldest events", s.dir, s.maxBytes, dropped)
+	}
+}
+
+// Peek 返回最早的批次, 没有批次时返回 nil
+func (s *spool) Peek() (string, []Event) {
+	for {
+		s.mu.Lock()
+		if len(s.files) == 0 {
+			s.mu.Unlock()
+			return "", nil
+		}
+		name := s.files[0].name
+		s.mu.Unlock()
+
+		events, err := s.read(name)
+		if err == nil && len(events) > 0 {
+			return name, events
+		}
+		if err != nil && !os.IsNotExist(err) {
+			logc.Errorf(context.Background(), "drop corrupted spool file %s: %v", filepath.Join(s.dir, name), err)
+		}
+		s.Remove(name)
+	}
+}
+
+// Remove 删除已发送的批次, 已被丢弃的批次忽略
+func (s *spool) Remove(name string) {
+	s.mu.Lock()
+	for i, f := range s.files {
+		if f.name == name {
+			s.files = append(s.files[:i], s.files[i+1:]...)
+			s.bytes -= f.size
+			break
+		}
+	}
+	s.mu.Unlock()
+	_ = os.Remove(filepath.Join(s.dir, name))
+}
+
+func (s *spool) read(name string) ([]Event, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	var events []Event
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		var ev Event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	return events, scanner.Err()
+}
+
+// encodeNDJSON 每行一个 JSON 格式的事件
+func encodeNDJSON(events []Event) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	for _, ev := range events {
+		if err := enc.Encode(ev); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
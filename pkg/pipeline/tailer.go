@@ -0,0 +1,629 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zeromicro/go-zero/core/logc"
+	logtypes "watchlog/log/config"
+	"watchlog/pkg/metrics"
+	"watchlog/pkg/tools"
+)
+
+const (
+	scanFrequency = 10 * time.Second
+	closeInactive = 2 * time.Hour
+	readBackoff   = time.Second
+	batchSize     = 512
+)
+
+// Tailer 原生采集器, 直接读取容器日志文件并通过 Router 发送到原生输出
+type Tailer struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	router      *Router
+	registry    *Registry
+	mu          sync.Mutex
+	watches     map[string]*watch
+	transcoders map[string]*tools.Transcoder
+}
+
+// watch 一个容器的原生采集任务
+type watch struct {
+	id         string
+	cancel     context.CancelFunc
+	configs    []logtypes.LogConfig
+	container  map[string]string
+	mu         sync.Mutex
+	harvesters map[string]bool
+	// limiters 日志的限速, 键为日志名称
+	limiters map[string]*rateLimiter
+	// samplers 日志的采样, 键为日志名称, 同一日志的全部文件共用计数
+	samplers map[string]*tools.Sampler
+}
+
+func NewTailer(router *Router, registryPath string) *Tailer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Tailer{
+		ctx:         ctx,
+		cancel:      cancel,
+		router:      router,
+		registry:    NewRegistry(registryPath),
+		watches:     make(map[string]*watch),
+		transcoders: make(map[string]*tools.Transcoder),
+	}
+}
+
+// Start 加载采集进度并定期保存
+func (t *Tailer) Start() error {
+	if err := t.registry.Load(); err != nil {
+		return fmt.Errorf("load native registry failed, err: %s", err.Error())
+	}
+	go t.registry.flush(t.ctx)
+	return nil
+}
+
+// Stop 停止所有采集任务并保存进度
+func (t *Tailer) Stop() {
+	t.cancel()
+	_ = t.registry.Save()
+	t.router.Close()
+}
+
+// HasOutput returns whether the named output is served by the tailer
+func (t *Tailer) HasOutput(name string) bool {
+	return t.router.Has(name)
+}
+
+// Buffers returns the spool stats of native outputs
+func (t *Tailer) Buffers() []BufferStats {
+	return t.router.Buffers()
+}
+
+// Registry returns the native file states
+func (t *Tailer) Registry() *Registry {
+	return t.registry
+}
+
+// Exists 判断容器是否存在原生采集任务
+func (t *Tailer) Exists(containerId string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.watches[containerId]
+	return ok
+}
+
+// Add 创建或替换容器的原生采集任务
+func (t *Tailer) Add(containerId string, container map[string]string, configs []logtypes.LogConfig) error {
+	for _, cfg := range configs {
+		if _, err := t.transcoder(cfg.Encoding); err != nil {
+			return err
+		}
+		if _, err := tools.NewMasker(cfg.Mask); err != nil {
+			return err
+		}
+		if cfg.Sample != nil {
+			if _, err := tools.NewSampler(cfg.Sample.Rates, cfg.Sample.Fields, cfg.Sample.Pattern); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 配置未变化时保留正在运行的采集任务
+	t.mu.Lock()
+	if old, ok := t.watches[containerId]; ok && reflect.DeepEqual(old.configs, configs) && reflect.DeepEqual(old.container, container) {
+		t.mu.Unlock()
+		return nil
+	}
+	t.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(t.ctx)
+	w := &watch{
+		id:         containerId,
+		cancel:     cancel,
+		configs:    configs,
+		container:  container,
+		harvesters: make(map[string]bool),
+		limiters:   make(map[string]*rateLimiter),
+		samplers:   make(map[string]*tools.Sampler),
+	}
+	for _, cfg := range configs {
+		if l := newRateLimiter(cfg.RateLimit, container["k8s_pod_namespace"], containerId, cfg.Name); l != nil {
+			w.limiters[cfg.Name] = l
+		}
+		if cfg.Sample != nil {
+			if s, _ := tools.NewSampler(cfg.Sample.Rates, cfg.Sample.Fields, cfg.Sample.Pattern); s != nil {
+				w.samplers[cfg.Name] = s
+			}
+		}
+	}
+
+	t.mu.Lock()
+	if old, ok := t.watches[containerId]; ok {
+		old.cancel()
+	}
+	t.watches[containerId] = w
+	t.mu.Unlock()
+
+	for _, cfg := range configs {
+		go t.scan(ctx, containerId, w, cfg)
+	}
+	return nil
+}
+
+// Remove 停止容器的原生采集任务, 采集进度保留在 registry 中
+func (t *Tailer) Remove(containerId string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w, ok := t.watches[containerId]
+	if ok {
+		w.cancel()
+		delete(t.watches, containerId)
+	}
+	return ok
+}
+
+// Reprocess 停止容器的原生采集任务, 将当前日志文件的进度重置为 0 后重新采集, 返回重置的文件
+func (t *Tailer) Reprocess(containerId string) ([]string, error) {
+	t.mu.Lock()
+	w, ok := t.watches[containerId]
+	if ok {
+		w.cancel()
+		delete(t.watches, containerId)
+	}
+	t.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("container %s has no native watch", containerId)
+	}
+
+	// 等待 harvester 退出, 避免旧的进度覆盖重置的进度
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		w.mu.Lock()
+		running := len(w.harvesters)
+		w.mu.Unlock()
+		if running == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			_ = t.Add(containerId, w.container, w.configs)
+			return nil, fmt.Errorf("wait for %d harvesters of %s timeout", running, containerId)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	var paths []string
+	t.files(containerId, w, func(cfg logtypes.LogConfig, path string, info os.FileInfo, state FileState) {
+		// 设置为 0 而不是删除, tail_files 的配置没有进度时从文件末尾开始
+		t.registry.Set(fmt.Sprintf("%s/%s/%s", containerId, cfg.Name, fileKey(info)), FileState{Source: path})
+		paths = append(paths, path)
+	})
+	return paths, t.Add(containerId, w.container, w.configs)
+}
+
+// Unshipped 返回容器日志文件中尚未发送的字节数, 键为文件路径, 已发送完的文件不返回
+func (t *Tailer) Unshipped(containerId string) map[string]int64 {
+	t.mu.Lock()
+	w, ok := t.watches[containerId]
+	t.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	pending := make(map[string]int64)
+	t.files(containerId, w, func(cfg logtypes.LogConfig, path string, info os.FileInfo, state FileState) {
+		if state.Offset < info.Size() {
+			pending[path] += info.Size() - state.Offset
+		}
+	})
+	return pending
+}
+
+// Lag 返回全部容器日志文件的采集延迟
+func (t *Tailer) Lag() []FileLag {
+	t.mu.Lock()
+	watches := make(map[string]*watch, len(t.watches))
+	for id, w := range t.watches {
+		watches[id] = w
+	}
+	t.mu.Unlock()
+
+	var ret []FileLag
+	for id, w := range watches {
+		t.files(id, w, func(cfg logtypes.LogConfig, path string, info os.FileInfo, state FileState) {
+			var outputs []string
+			for _, output := range cfg.Outputs {
+				if t.router.Has(output) {
+					outputs = append(outputs, output)
+				}
+			}
+			lag := NewFileLag(id, strings.Join(outputs, ","), "native", path, info.Size(), state.Offset, state.Updated)
+			lag.Namespace = w.container["k8s_pod_namespace"]
+			ret = append(ret, lag)
+		})
+	}
+	return ret
+}
+
+// files 遍历容器当前采集的日志文件及进度
+func (t *Tailer) files(containerId string, w *watch, fn func(cfg logtypes.LogConfig, path string, info os.FileInfo, state FileState)) {
+	for _, cfg := range w.configs {
+		paths, _ := filepath.Glob(filepath.Join(cfg.HostDir, cfg.File))
+		for _, path := range paths {
+			if strings.HasSuffix(path, ".gz") {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			if cfg.IgnoreOlder != "" {
+				if d, _ := time.ParseDuration(cfg.IgnoreOlder); time.Since(info.ModTime()) > d {
+					continue
+				}
+			}
+			state, _ := t.registry.Get(fmt.Sprintf("%s/%s/%s", containerId, cfg.Name, fileKey(info)))
+			fn(cfg, path, info, state)
+		}
+	}
+}
+
+func (t *Tailer) transcoder(encoding string) (*tools.Transcoder, error) {
+	if encoding == "" {
+		encoding = "utf-8"
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tc, ok := t.transcoders[encoding]; ok {
+		return tc, nil
+	}
+	tc, err := tools.NewTranscoder(encoding)
+	if err != nil {
+		return nil, err
+	}
+	t.transcoders[encoding] = tc
+	return tc, nil
+}
+
+// scan 定期查找日志文件并为新文件启动 harvester
+func (t *Tailer) scan(ctx context.Context, containerId string, w *watch, cfg logtypes.LogConfig) {
+	ticker := time.NewTicker(scanFrequency)
+	defer ticker.Stop()
+	for {
+		paths, err := filepath.Glob(filepath.Join(cfg.HostDir, cfg.File))
+		if err != nil {
+			logc.Errorf(context.Background(), "glob %s/%s failed: %v", cfg.HostDir, cfg.File, err)
+		}
+
+		for _, path := range paths {
+			if strings.HasSuffix(path, ".gz") {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			if cfg.IgnoreOlder != "" {
+				if d, _ := time.ParseDuration(cfg.IgnoreOlder); time.Since(info.ModTime()) > d {
+					continue
+				}
+			}
+
+			key := fmt.Sprintf("%s/%s/%s", containerId, cfg.Name, fileKey(info))
+			w.mu.Lock()
+			running := w.harvesters[key]
+			w.harvesters[key] = true
+			w.mu.Unlock()
+			if running {
+				continue
+			}
+
+			go func(path, key string, info os.FileInfo) {
+				defer func() {
+					w.mu.Lock()
+					delete(w.harvesters, key)
+					w.mu.Unlock()
+				}()
+				if err := t.harvest(ctx, key, path, info, cfg, w); err != nil {
+					logc.Errorf(context.Background(), "harvest %s failed: %v", path, err)
+				}
+			}(path, key, info)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// harvester 单个文件的读取状态
+type harvester struct {
+	cfg     logtypes.LogConfig
+	fields  map[string]string
+	source  string
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	tc      *tools.Transcoder
+	// invalid 转码时替换的非法字节序列
+	invalid prometheus.Counter
+	ml      *multiline
+	masker  *tools.Masker
+	limiter *rateLimiter
+	sampler *tools.Sampler
+	// sampled 带有采样比例字段的 fields, 键为采样比例
+	sampled map[int]map[string]string
+	// partials 被拆分的行已读取的部分, stdout 与 stderr 的片段可能交错写入, 键为 stream
+	partials map[string]*partialLine
+	// bufStart 未完成的多行的起始偏移, 进度只能提交到这里
+	bufStart int64
+	events   []Event
+}
+
+// partialLine 被拆分的行已读取的部分及第一个片段的起始偏移
+type partialLine struct {
+	content []byte
+	start   int64
+}
+
+func (t *Tailer) harvest(ctx context.Context, key, path string, info os.FileInfo, cfg logtypes.LogConfig, w *watch) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var offset int64
+	if state, ok := t.registry.Get(key); ok {
+		offset = state.Offset
+	} else if cfg.TailFiles {
+		offset = info.Size()
+	}
+	if offset > info.Size() {
+		// 文件被截断
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	tc, err := t.transcoder(cfg.Encoding)
+	if err != nil {
+		return err
+	}
+	masker, err := tools.NewMasker(cfg.Mask)
+	if err != nil {
+		return err
+	}
+	encoding := cfg.Encoding
+	if encoding == "" {
+		encoding = "utf-8"
+	}
+	h := &harvester{
+		cfg:      cfg,
+		fields:   make(map[string]string),
+		source:   path,
+		tc:       tc,
+		invalid:  metrics.InvalidSequences.WithLabelValues(w.container["k8s_pod_namespace"], w.id, cfg.Name, encoding),
+		ml:       newMultiline(cfg.Multiline),
+		masker:   masker,
+		limiter:  w.limiters[cfg.Name],
+		sampler:  w.samplers[cfg.Name],
+		partials: make(map[string]*partialLine),
+	}
+	for k, v := range cfg.Tags {
+		h.fields[k] = v
+	}
+	for k, v := range w.container {
+		h.fields[k] = v
+	}
+	for _, p := range cfg.IncludeLines {
+		h.include = append(h.include, regexp.MustCompile(p))
+	}
+	for _, p := range cfg.ExcludeLines {
+		h.exclude = append(h.exclude, regexp.MustCompile(p))
+	}
+
+	reader := bufio.NewReaderSize(f, 64*1024)
+	var (
+		pending  []byte
+		lastRead = time.Now()
+	)
+	for {
+		raw, err := reader.ReadBytes('\n')
+		if err == nil {
+			lineStart := offset - int64(len(pending))
+			offset += int64(len(raw))
+			h.process(append(pending, raw...), lineStart, offset)
+			pending = nil
+			lastRead = time.Now()
+			if len(h.events) < batchSize {
+				continue
+			}
+		} else if err != io.EOF {
+			return err
+		} else {
+			// 不完整的行等待写入完成
+			pending = append(pending, raw...)
+			offset += int64(len(raw))
+			if h.ml != nil && h.ml.expired() {
+				h.emit(h.ml.flush())
+			}
+		}
+
+		if err := t.publish(ctx, key, h, offset-int64(len(pending))); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(readBackoff):
+		}
+
+		// 文件已删除或长时间没有写入时关闭, 仍然存在的文件会在下次扫描时重新打开
+		if stat, err := f.Stat(); err == nil {
+			if s, ok := stat.Sys().(*syscall.Stat_t); ok && s.Nlink == 0 {
+				return nil
+			}
+		}
+		if time.Since(lastRead) > closeInactive {
+			return nil
+		}
+	}
+}
+
+// process 解析一行完整的日志
+func (h *harvester) process(raw []byte, lineStart, lineEnd int64) {
+	l, err := parseLine(raw[:len(raw)-1])
+	if err != nil {
+		logc.Debugf(context.Background(), "skip %s line at %d: %v", h.source, lineStart, err)
+		return
+	}
+
+	// CRI 及 docker 将超长的行拆分为多个部分
+	if l.partial {
+		p, ok := h.partials[l.stream]
+		if !ok {
+			p = &partialLine{start: lineStart}
+			h.partials[l.stream] = p
+		}
+		p.content = append(p.content, l.content...)
+		return
+	}
+	content := l.content
+	// 合并后的行从第一个片段开始
+	start := lineStart
+	if p, ok := h.partials[l.stream]; ok {
+		content = append(p.content, content...)
+		start = p.start
+		delete(h.partials, l.stream)
+	}
+
+	if h.cfg.Stream != "" && h.cfg.Stream != logtypes.StreamAll && h.cfg.Stream != l.stream {
+		return
+	}
+
+	message, invalid := h.tc.Decode(content)
+	if invalid > 0 {
+		h.invalid.Add(float64(invalid))
+	}
+	ev := Event{
+		Timestamp: l.timestamp,
+		Message:   message,
+		Stream:    l.stream,
+		Source:    h.source,
+		Offset:    lineEnd,
+		Fields:    h.fields,
+	}
+	if h.ml == nil {
+		h.emit([]Event{ev})
+		return
+	}
+	h.emit(h.ml.add(ev))
+	if h.ml.lines == 1 {
+		h.bufStart = start
+	}
+}
+
+// emit 按 include_lines / exclude_lines 过滤, 采样, 脱敏并限速后加入待发送的事件
+func (h *harvester) emit(events []Event) {
+	for _, ev := range events {
+		if len(h.include) > 0 && !matchAny(h.include, ev.Message) {
+			continue
+		}
+		if matchAny(h.exclude, ev.Message) {
+			continue
+		}
+		if h.sampler != nil {
+			keep, rate := h.sampler.Sample(ev.Message)
+			if !keep {
+				continue
+			}
+			ev.Fields = h.sampledFields(rate)
+		}
+		if h.masker != nil {
+			ev.Message = h.masker.Mask(ev.Message)
+		}
+		// 超过限速的事件丢弃, 进度照常推进
+		if h.limiter != nil && !h.limiter.allow(len(ev.Message)) {
+			continue
+		}
+		h.events = append(h.events, ev)
+	}
+}
+
+// sampledFields 返回带有采样比例的 fields, 同一比例的事件共用
+func (h *harvester) sampledFields(rate int) map[string]string {
+	if h.sampled == nil {
+		h.sampled = make(map[int]map[string]string)
+	}
+	fields, ok := h.sampled[rate]
+	if !ok {
+		fields = make(map[string]string, len(h.fields)+1)
+		for k, v := range h.fields {
+			fields[k] = v
+		}
+		fields[tools.SampleRateField] = strconv.Itoa(rate)
+		h.sampled[rate] = fields
+	}
+	return fields
+}
+
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, p := range patterns {
+		if p.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// committable 返回不超过 offset 的可提交进度, 未完成的多行及被拆分的行不能提交
+func (h *harvester) committable(offset int64) int64 {
+	for _, p := range h.partials {
+		if p.start < offset {
+			offset = p.start
+		}
+	}
+	if h.ml != nil && h.ml.buf != nil && h.bufStart < offset {
+		offset = h.bufStart
+	}
+	return offset
+}
+
+// publish 发送待发送的事件并在所有输出确认后提交进度
+func (t *Tailer) publish(ctx context.Context, key string, h *harvester, offset int64) error {
+	offset = h.committable(offset)
+	if len(h.events) > 0 {
+		// Router 在输出恢复前一直重试, 返回错误说明任务已停止, 不提交进度
+		if err := t.router.Route(ctx, h.cfg.Outputs, h.events); err != nil {
+			return nil
+		}
+		h.events = h.events[:0]
+	}
+
+	if state, ok := t.registry.Get(key); !ok || state.Offset != offset {
+		t.registry.Set(key, FileState{Source: h.source, Offset: offset})
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	logtypes "watchlog/log/config"
+	"watchlog/pkg/tools"
+)
+
+func dockerLog(message string) string {
+	return fmt.Sprintf(`{"log":%q,"stream":"stdout","time":"2024-01-01T00:00:00Z"}`+"\n", message)
+}
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func messages(events []Event) []string {
+	var ret []string
+	for _, ev := range events {
+		ret = append(ret, ev.Message)
+	}
+	return ret
+}
+
+// newTestTailer 创建只有原生输出 a 的采集器
+func newTestTailer(t *testing.T, registry string) (*Tailer, *fakeSink) {
+	t.Helper()
+	sink := &fakeSink{}
+	router := NewRouter()
+	if err := router.Add("a", sink, Batch{Size: 100, Wait: 10 * time.Millisecond, MaxBackoff: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	tailer := NewTailer(router, registry)
+	if err := tailer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return tailer, sink
+}
+
+func testLogConfig(dir string) []logtypes.LogConfig {
+	return []logtypes.LogConfig{{
+		Name:    "app",
+		HostDir: dir,
+		File:    "c.log*",
+		Stdout:  true,
+		Stream:  logtypes.StreamAll,
+		Outputs: []string{"a"},
+	}}
+}
+
+// committed 返回文件在 registry 中的进度
+func committed(tailer *Tailer, path string) int64 {
+	for _, state := range tailer.Registry().States() {
+		if state.Source == path {
+			return state.Offset
+		}
+	}
+	return -1
+}
+
+func TestTailerOffsetAndPartialLines(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "c.log")
+	registry := filepath.Join(t.TempDir(), "registry.json")
+
+	first := dockerLog("one\n")
+	appendFile(t, path, first)
+	// 未写完的行不发送, 进度停在上一个完整的行
+	half := dockerLog("two\n")
+	appendFile(t, path, half[:10])
+
+	tailer, sink := newTestTailer(t, registry)
+	if err := tailer.Add("c1", nil, testLogConfig(dir)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "first line", func() bool { return committed(tailer, path) == int64(len(first)) })
+	if got := messages(sink.received()); !reflect.DeepEqual(got, []string{"one"}) {
+		t.Fatalf("received %v, want [one]", got)
+	}
+
+	// docker 拆分的超长行(不以换行结尾)与下一部分合并
+	appendFile(t, path, half[10:]+dockerLog("long ")+dockerLog("line\n"))
+	waitFor(t, "completed lines", func() bool { return len(sink.received()) == 3 })
+	if got := messages(sink.received()); !reflect.DeepEqual(got, []string{"one", "two", "long line"}) {
+		t.Fatalf("received %v, want [one two long line]", got)
+	}
+	info, _ := os.Stat(path)
+	waitFor(t, "offset at end of file", func() bool { return committed(tailer, path) == info.Size() })
+	tailer.Stop()
+
+	// 重启后从保存的进度继续, 不重复发送
+	appendFile(t, path, dockerLog("three\n"))
+	tailer, sink = newTestTailer(t, registry)
+	defer tailer.Stop()
+	if err := tailer.Add("c1", nil, testLogConfig(dir)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "line after restart", func() bool { return len(sink.received()) > 0 })
+	time.Sleep(200 * time.Millisecond)
+	if got := messages(sink.received()); !reflect.DeepEqual(got, []string{"three"}) {
+		t.Errorf("received %v after restart, want [three]", got)
+	}
+}
+
+func TestTailerRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "c.log")
+	appendFile(t, path, dockerLog("before\n"))
+
+	tailer, sink := newTestTailer(t, filepath.Join(t.TempDir(), "registry.json"))
+	defer tailer.Stop()
+	if err := tailer.Add("c1", nil, testLogConfig(dir)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "line before rotation", func() bool { return len(sink.received()) == 1 })
+
+	// 轮转: 原文件重命名后继续写完剩余的行, 新文件从头采集
+	rotated := path + ".1"
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, rotated, dockerLog("rotated\n"))
+	appendFile(t, path, dockerLog("after\n"))
+
+	// 重新扫描, 进度以 inode 为键, 重命名的文件不会重复采集
+	tailer.Remove("c1")
+	if err := tailer.Add("c1", nil, testLogConfig(dir)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "rotated lines", func() bool { return len(sink.received()) >= 3 })
+	time.Sleep(200 * time.Millisecond)
+	got := messages(sink.received())
+	if len(got) == 3 {
+		sort.Strings(got[1:])
+	}
+	if want := []string{"before", "after", "rotated"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want before followed by rotated and after", got)
+	}
+}
+
+func TestRegistryRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "c.log")
+	appendFile(t, source, "x")
+	path := filepath.Join(dir, "data", "registry.json")
+
+	r := NewRegistry(path)
+	r.Set("c1/app/1-2", FileState{Source: source, Offset: 42})
+	r.Set("c1/app/1-3", FileState{Source: source, Offset: 7})
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file %s.tmp left behind", path)
+	}
+
+	loaded := NewRegistry(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	want := r.States()
+	got := loaded.States()
+	if len(got) != 2 {
+		t.Fatalf("loaded %d states, want 2", len(got))
+	}
+	for key, state := range want {
+		if g := got[key]; g.Source != state.Source || g.Offset != state.Offset || !g.Updated.Equal(state.Updated) {
+			t.Errorf("state %s = %+v, want %+v", key, g, state)
+		}
+	}
+
+	// 不存在的文件视为没有进度
+	if err := NewRegistry(filepath.Join(dir, "missing.json")).Load(); err != nil {
+		t.Errorf("Load of missing registry = %v, want nil", err)
+	}
+}
+
+// criLog 返回一行 CRI 格式的日志, tag 为 P 时是被拆分的行的一部分
+func criLog(stream, tag, message string) string {
+	return fmt.Sprintf("2024-01-01T00:00:00Z %s %s %s\n", stream, tag, message)
+}
+
+func newTestHarvester(t *testing.T, cfg logtypes.LogConfig) *harvester {
+	t.Helper()
+	tc, err := tools.NewTranscoder("utf-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &harvester{
+		cfg:      cfg,
+		fields:   map[string]string{},
+		source:   "c.log",
+		tc:       tc,
+		invalid:  prometheus.NewCounter(prometheus.CounterOpts{Name: "invalid"}),
+		ml:       newMultiline(cfg.Multiline),
+		partials: make(map[string]*partialLine),
+	}
+}
+
+// feed 依次处理 lines, 返回每行的起始偏移及结束偏移
+func feed(h *harvester, lines ...string) ([]int64, int64) {
+	var (
+		starts []int64
+		offset int64
+	)
+	for _, l := range lines {
+		starts = append(starts, offset)
+		offset += int64(len(l))
+		h.process([]byte(l), starts[len(starts)-1], offset)
+	}
+	return starts, offset
+}
+
+func TestHarvesterPartialStreams(t *testing.T) {
+	h := newTestHarvester(t, logtypes.LogConfig{Name: "app", Stream: logtypes.StreamAll})
+
+	// stdout 与 stderr 被拆分的行交错写入, 各自合并
+	starts, end := feed(h,
+		criLog("stdout", "P", "out-"),
+		criLog("stderr", "P", "err-"),
+		criLog("stdout", "F", "line"),
+	)
+	if got := messages(h.events); !reflect.DeepEqual(got, []string{"out-line"}) {
+		t.Fatalf("events = %v, want [out-line]", got)
+	}
+	// stderr 的行未完成, 进度停在它的第一个片段
+	if got := h.committable(end); got != starts[1] {
+		t.Errorf("committable = %d, want %d", got, starts[1])
+	}
+
+	line := criLog("stderr", "F", "line")
+	h.process([]byte(line), end, end+int64(len(line)))
+	if got := messages(h.events); !reflect.DeepEqual(got, []string{"out-line", "err-line"}) {
+		t.Fatalf("events = %v, want [out-line err-line]", got)
+	}
+	if h.events[1].Stream != "stderr" {
+		t.Errorf("stream = %s, want stderr", h.events[1].Stream)
+	}
+	if got, want := h.committable(end+int64(len(line))), end+int64(len(line)); got != want {
+		t.Errorf("committable = %d, want %d", got, want)
+	}
+}
+
+func TestHarvesterPartialMultiline(t *testing.T) {
+	h := newTestHarvester(t, logtypes.LogConfig{
+		Name:      "app",
+		Stream:    logtypes.StreamAll,
+		Multiline: &logtypes.Multiline{Pattern: `^\s`, Match: "after"},
+	})
+
+	starts, end := feed(h,
+		criLog("stdout", "F", "first"),
+		criLog("stdout", "F", "  at first"),
+		// 被拆分的行开始新的多行, 未完成的多行从第一个片段开始
+		criLog("stdout", "P", "sec"),
+		criLog("stdout", "F", "ond"),
+	)
+	if got := messages(h.events); !reflect.DeepEqual(got, []string{"first\n  at first"}) {
+		t.Fatalf("events = %v, want the first multiline event", got)
+	}
+	if h.bufStart != starts[2] {
+		t.Errorf("bufStart = %d, want %d", h.bufStart, starts[2])
+	}
+	if got := h.committable(end); got != starts[2] {
+		t.Errorf("committable = %d, want %d", got, starts[2])
+	}
+}
@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+
+	"watchlog/pkg/tools"
+)
+
+func init() {
+	RegisterSink("http", newWebhook)
+}
+
+// webhook 以 NDJSON 格式批量发送到 HTTP 接口, 每行一个事件
+//
+// HTTP_URL 接口地址
+// HTTP_METHOD POST 或 PUT, 默认 POST
+// HTTP_HEADERS 请求头, 格式为 k=v,k=v, 例如 Authorization=Bearer xxx
+// HTTP_COMPRESSION gzip
+//
+// 接口返回的任何非 2xx 状态码均重试, 开启暂存时写入暂存, 不会丢弃日志.
+type webhook struct {
+	url     string
+	method  string
+	headers map[string]string
+	gzip    bool
+	client  *http.Client
+}
+
+func newWebhook(name string, env *tools.Env) (Sink, error) {
+	w := &webhook{
+		url:     env.Required("HTTP_URL"),
+		method:  env.Str("HTTP_METHOD"),
+		headers: loadHeaders(env, "HTTP"),
+		gzip:    loadGzip(env, "HTTP"),
+		client:  newHTTPClient(env, "HTTP"),
+	}
+	if w.url != "" {
+		if u, err := url.Parse(w.url); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			env.Errorf("%sHTTP_URL must be a http(s) url, got %q", env.Prefix, w.url)
+		}
+	}
+	switch w.method {
+	case "":
+		w.method = http.MethodPost
+	case http.MethodPost, http.MethodPut:
+	default:
+		env.Errorf("%sHTTP_METHOD must be POST or PUT, got %q", env.Prefix, w.method)
+	}
+	return w, nil
+}
+
+func (w *webhook) Send(ctx context.Context, events []Event) error {
+	body, err := encodeNDJSON(events)
+	if err != nil {
+		return Permanent(err)
+	}
+	if w.gzip {
+		body = gzipBytes(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, w.method, w.url, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if w.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+	return doHTTP(w.client, req)
+}
+
+func (w *webhook) Close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,126 @@
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ndjson 解析请求体中的事件, 按 Content-Encoding 解压
+func ndjson(t *testing.T, req *http.Request, body []byte) []Event {
+	t.Helper()
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, err = ioutil.ReadAll(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var events []Event
+	for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+		var ev Event
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("invalid ndjson line %q: %v", line, err)
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestWebhookBatching(t *testing.T) {
+	srv := newPushServer(t)
+	sink := newTestSink(t, "http", "batch", map[string]string{
+		"HTTP_URL":         srv.URL + "/ingest",
+		"HTTP_METHOD":      "PUT",
+		"HTTP_HEADERS":     "Authorization=Bearer token,X-Team=pay",
+		"HTTP_COMPRESSION": "gzip",
+	})
+	r := NewRouter()
+	defer r.Close()
+	if err := r.Add("batch", sink, Batch{Size: 3, Wait: 200 * time.Millisecond, MaxBackoff: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 并发的请求在 Wait 内合并为一批, 达到 Size 后立即发送
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errs := make(chan error, 3)
+	for _, m := range []string{"1", "2", "3"} {
+		go func(m string) { errs <- r.Route(ctx, []string{"batch"}, testEvents(m)) }(m)
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := srv.count(); n != 1 {
+		t.Fatalf("server got %d requests, want 1 batch", n)
+	}
+	req, body := srv.last()
+	if req.Method != http.MethodPut || req.URL.Path != "/ingest" {
+		t.Errorf("request %s %s, want PUT /ingest", req.Method, req.URL.Path)
+	}
+	if req.Header.Get("Authorization") != "Bearer token" || req.Header.Get("X-Team") != "pay" || req.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("request headers = %v", req.Header)
+	}
+	if events := ndjson(t, req, body); len(events) != 3 {
+		t.Errorf("batch has %d events, want 3", len(events))
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	// 4xx 同样重试, 认证或地址修复后继续发送
+	srv := newPushServer(t, http.StatusUnauthorized, http.StatusNotFound, http.StatusBadRequest)
+	sink := newTestSink(t, "http", "retry", map[string]string{"HTTP_URL": srv.URL})
+	r := NewRouter()
+	defer r.Close()
+	if err := r.Add("retry", sink, Batch{Size: 10, Wait: 10 * time.Millisecond, MaxBackoff: 100 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := r.Route(ctx, []string{"retry"}, testEvents("1", "2")); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.count(); n != 4 {
+		t.Errorf("server got %d requests, want 3 failures and 1 success", n)
+	}
+	req, body := srv.last()
+	if events := ndjson(t, req, body); len(events) != 2 || events[0].Message != "1" || events[1].Message != "2" {
+		t.Errorf("delivered %v, want [1 2]", messages(events))
+	}
+}
+
+func TestWebhookSpool(t *testing.T) {
+	// 4xx 时写入暂存, 采集不阻塞, 恢复后发送暂存的日志
+	srv := newPushServer(t, http.StatusForbidden)
+	sink := newTestSink(t, "http", "spool", map[string]string{"HTTP_URL": srv.URL})
+	r := NewRouter()
+	defer r.Close()
+	batch := Batch{Size: 10, Wait: 10 * time.Millisecond, MaxBackoff: time.Second, Spool: true, SpoolDir: t.TempDir()}
+	if err := r.Add("spool", sink, batch); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := r.Route(ctx, []string{"spool"}, testEvents("1")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "spooled batch delivered", func() bool { return srv.count() == 2 })
+	req, body := srv.last()
+	if events := ndjson(t, req, body); len(events) != 1 || events[0].Message != "1" {
+		t.Errorf("delivered %v, want [1]", messages(events))
+	}
+	waitFor(t, "spool drained", func() bool { return r.Buffers()[0].Batches == 0 })
+}
@@ -0,0 +1,201 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logc"
+	"watchlog/pkg/tools"
+)
+
+// ConfigGeneration 容器采集配置的版本, 内容变化时 Generation 加一, 重新采集时 Epoch 加一
+type ConfigGeneration struct {
+	Container  string    `json:"container"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Output     string    `json:"output"`
+	Path       string    `json:"path"`
+	Hash       string    `json:"hash"`
+	Generation uint64    `json:"generation"`
+	Epoch      uint64    `json:"epoch,omitempty"`
+	Updated    time.Time `json:"updated"`
+}
+
+// LoadIndex 加载配置索引, 丢弃配置文件已不存在的记录
+func (f *FilebeatPointer) LoadIndex() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.index = make(map[string]*ConfigGeneration)
+	data, err := ioutil.ReadFile(f.GetIndexPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &f.index); err != nil {
+		// 索引损坏不影响采集, 重新生成
+		logc.Errorf(context.Background(), "parse config index %s failed, err: %s", f.GetIndexPath(), err.Error())
+		f.index = make(map[string]*ConfigGeneration)
+		return nil
+	}
+	for container := range f.index {
+		if _, err := os.Stat(f.GetConfPath(container)); err != nil {
+			delete(f.index, container)
+		}
+	}
+	return nil
+}
+
+// WriteConfig 写入容器的采集配置, 内容与现有配置一致时跳过, 返回是否写入
+func (f *FilebeatPointer) WriteConfig(container, namespace string, data []byte) (bool, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	path := f.GetConfPath(container)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.index == nil {
+		f.index = make(map[string]*ConfigGeneration)
+	}
+
+	g, ok := f.index[container]
+	if current, err := ioutil.ReadFile(path); err == nil && bytes.Equal(current, data) {
+		if ok && g.Hash == hash {
+			return false, nil
+		}
+		// 索引丢失, 沿用已有的配置
+		updated := time.Now()
+		if stat, err := os.Stat(path); err == nil {
+			updated = stat.ModTime()
+		}
+		f.index[container] = &ConfigGeneration{Container: container, Namespace: namespace, Output: f.Output, Path: path, Hash: hash, Generation: 1, Updated: updated}
+		return false, f.saveIndex()
+	}
+
+	if err := tools.WriteFileAtomic(path, data, 0644); err != nil {
+		return false, err
+	}
+
+	var generation, epoch uint64 = 1, 0
+	if ok {
+		generation, epoch = g.Generation+1, g.Epoch
+	}
+	f.index[container] = &ConfigGeneration{Container: container, Namespace: namespace, Output: f.Output, Path: path, Hash: hash, Generation: generation, Epoch: epoch, Updated: time.Now()}
+	return true, f.saveIndex()
+}
+
+// Epoch 返回容器配置的重新采集次数
+func (f *FilebeatPointer) Epoch(container string) uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if g, ok := f.index[container]; ok {
+		return g.Epoch
+	}
+	return 0
+}
+
+// NextEpoch 增加容器配置的重新采集次数, 下次生成配置时使用新的 input ID
+func (f *FilebeatPointer) NextEpoch(container string) (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g, ok := f.index[container]
+	if !ok {
+		return 0, fmt.Errorf("container %s has no config", container)
+	}
+	g.Epoch++
+	return g.Epoch, f.saveIndex()
+}
+
+// RemoveConfig 删除容器的采集配置, 返回删除的配置, 配置不存在时返回 nil
+func (f *FilebeatPointer) RemoveConfig(container string) (*ConfigGeneration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := f.GetConfPath(container)
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	g, ok := f.index[container]
+	if ok {
+		delete(f.index, container)
+		if err := f.saveIndex(); err != nil {
+			logc.Errorf(context.Background(), "save config index %s failed, err: %s", f.GetIndexPath(), err.Error())
+		}
+	}
+	if err != nil {
+		return nil, nil
+	}
+	if !ok {
+		g = &ConfigGeneration{Container: container, Output: f.Output, Path: path}
+	}
+	return g, nil
+}
+
+// StaleConfigs 返回不在 keep 中的容器配置, 并清理残留的临时文件, since 之后写入的配置不返回
+func (f *FilebeatPointer) StaleConfigs(keep map[string]bool, since time.Time) ([]string, error) {
+	confDir := f.GetConfHome()
+	entries, err := ioutil.ReadDir(confDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []string
+	for _, e := range entries {
+		// 命名输出的配置目录
+		if !e.Mode().IsRegular() || !e.ModTime().Before(since) {
+			continue
+		}
+		if !strings.HasSuffix(e.Name(), ".yml") {
+			if err := os.Remove(filepath.Join(confDir, e.Name())); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			continue
+		}
+		if container := strings.TrimSuffix(e.Name(), ".yml"); !keep[container] {
+			stale = append(stale, container)
+		}
+	}
+	return stale, nil
+}
+
+// Generations returns the config generations sorted by container
+func (f *FilebeatPointer) Generations() []ConfigGeneration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ret := make([]ConfigGeneration, 0, len(f.index))
+	for _, g := range f.index {
+		ret = append(ret, *g)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Container < ret[j].Container
+	})
+	return ret
+}
+
+func (f *FilebeatPointer) saveIndex() error {
+	data, err := json.Marshal(f.index)
+	if err != nil {
+		return err
+	}
+	path := f.GetIndexPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := tools.WriteFileAtomic(path, data, 0644); err != nil {
+		return fmt.Errorf("write config index failed, err: %s", err.Error())
+	}
+	return nil
+}
@@ -9,107 +9,256 @@ import (
 	"github.com/elastic/go-ucfg/yaml"
 	"github.com/zeromicro/go-zero/core/logc"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"text/template"
+	"time"
 	logtypes "watchlog/log/config"
+	"watchlog/pkg/metrics"
 )
 
-// FilebeatPointer Filebeat 插件
+// FilebeatPointer Filebeat 插件, 每个 Filebeat 类型的输出对应一个采集器实例
 type FilebeatPointer struct {
 	cmd     *exec.Cmd
 	Name    string
+	Output  string
 	Tmpl    *template.Template
 	BaseDir string
+	// root 配置及数据目录的前缀, 测试时指向临时目录, 默认为空
+	root string
+	mu   sync.Mutex
+	// index 容器采集配置的版本, 键为容器 ID
+	index map[string]*ConfigGeneration
+	// 采集器的运行状态
+	running  bool
+	started  time.Time
+	restarts uint64
+	// exits 最近的退出及启动失败的时间, 用于判断是否频繁重启
+	exits []time.Time
+	// hold 非空时采集器由 Restart 主动停止, 关闭后重新启动
+	hold    chan struct{}
+	stopped chan struct{}
 }
 
-func NewFilebeatPointer(Tmpl *template.Template, BaseDir string) FilebeatPointer {
-	return FilebeatPointer{
-		Name:    "Filebeat",
+// maxExits 保留的退出记录数
+const maxExits = 16
+
+func NewFilebeatPointer(Tmpl *template.Template, BaseDir, Output string) *FilebeatPointer {
+	name := "Filebeat"
+	if Output != logtypes.DefaultOutput {
+		name = fmt.Sprintf("Filebeat[%s]", Output)
+	}
+	return &FilebeatPointer{
+		Name:    name,
+		Output:  Output,
 		Tmpl:    Tmpl,
 		BaseDir: BaseDir,
+		index:   make(map[string]*ConfigGeneration),
 	}
 }
 
-// Start 启动采集器
-func (f FilebeatPointer) Start() error {
+// Start 启动采集器, 采集器退出后自动重启
+func (f *FilebeatPointer) Start() error {
 	if f.cmd != nil {
 		pid := f.cmd.Process.Pid
-		return fmt.Errorf("Filebeat process is exists, PID: %d", pid)
+		return fmt.Errorf("%s process is exists, PID: %d", f.Name, pid)
 	}
 
-	f.cmd = exec.Command(FilebeatExecCmd, "-c", FilebeatConfFile)
-	f.cmd.Stderr = os.Stderr
-	f.cmd.Stdout = os.Stdout
-	err := f.cmd.Start()
-	if err != nil {
-		logc.Errorf(context.Background(), "Filebeat start fail: %s", err)
+	if err := f.start(); err != nil {
+		return err
+	}
+
+	go f.supervise()
+	return nil
+}
+
+func (f *FilebeatPointer) start() error {
+	cmd := exec.Command(FilebeatExecCmd, "-c", f.GetConfFile(), "--path.data", f.GetDataPath(), "--path.logs", f.GetLogsPath())
+	cmd.Stderr = os.Stderr
+	cmd.Stdout = os.Stdout
+	if err := cmd.Start(); err != nil {
+		logc.Errorf(context.Background(), "%s start fail: %s", f.Name, err)
+		return err
 	}
 
-	go func() {
-		logc.Infof(context.Background(), "Starting Filebeat pid: %v", f.cmd.Process.Pid)
+	f.mu.Lock()
+	f.cmd = cmd
+	f.running = true
+	f.started = time.Now()
+	f.mu.Unlock()
+	logc.Infof(context.Background(), "Starting %s pid: %v", f.Name, cmd.Process.Pid)
+	return nil
+}
+
+// supervise 等待采集器退出并重启, 频繁退出时按指数退避
+func (f *FilebeatPointer) supervise() {
+	backoff := time.Second
+	for {
+		started := time.Now()
 		err := f.cmd.Wait()
+
+		f.mu.Lock()
+		hold, stopped := f.hold, f.stopped
+		f.mu.Unlock()
+		if hold != nil {
+			// Restart 主动停止, 等待调用方完成后立即启动
+			f.mu.Lock()
+			f.running = false
+			f.mu.Unlock()
+			close(stopped)
+			<-hold
+			if f.start() == nil {
+				continue
+			}
+		}
+
+		f.exited()
 		if err != nil {
-			logc.Errorf(context.Background(), "Filebeat exited: %v", err)
+			logc.Errorf(context.Background(), "%s exited: %v", f.Name, err)
 			if exitError, ok := err.(*exec.ExitError); ok {
 				processState := exitError.ProcessState
-				logc.Errorf(context.Background(), "Filebeat exited pid: %v", processState.Pid())
+				logc.Errorf(context.Background(), "%s exited pid: %v", f.Name, processState.Pid())
 			}
 		}
 
-		// try to restart filebeat
-		logc.Debugf(context.Background(), "Filebeat exited and try to restart")
-		f.cmd = nil
-		err = f.cmd.Start()
-		if err != nil {
-			return
+		// 稳定运行一段时间后重置退避
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
 		}
+
+		for {
+			logc.Infof(context.Background(), "%s exited and try to restart in %s", f.Name, backoff)
+			time.Sleep(backoff)
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+			if f.start() == nil {
+				break
+			}
+			f.exited()
+		}
+		f.mu.Lock()
+		f.restarts++
+		f.mu.Unlock()
+		metrics.CollectorRestarts.WithLabelValues(f.Output).Inc()
+	}
+}
+
+// Restart 停止采集器, 执行 fn 后重新启动, 用于只能在采集器停止时修改的仓库
+func (f *FilebeatPointer) Restart(fn func() error) error {
+	f.mu.Lock()
+	if f.hold != nil {
+		f.mu.Unlock()
+		return fmt.Errorf("%s is restarting", f.Name)
+	}
+	if !f.running || f.cmd == nil {
+		f.mu.Unlock()
+		return fmt.Errorf("%s is not running", f.Name)
+	}
+	hold, stopped := make(chan struct{}), make(chan struct{})
+	f.hold, f.stopped = hold, stopped
+	cmd := f.cmd
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		f.hold, f.stopped = nil, nil
+		f.mu.Unlock()
+		close(hold)
 	}()
 
-	return err
+	logc.Infof(context.Background(), "Stopping %s pid: %v", f.Name, cmd.Process.Pid)
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		return err
+	}
+	select {
+	case <-stopped:
+	case <-time.After(30 * time.Second):
+		_ = cmd.Process.Kill()
+		<-stopped
+	}
+	return fn()
 }
 
-// GetRegistryState 获取 filebeat 仓库中容器日志的基本信息
-func (f FilebeatPointer) GetRegistryState() (map[string]RegistryState, error) {
-	file, err := os.Open(FilebeatRegistry)
-	if err != nil {
-		return nil, err
+// exited 记录采集器退出或启动失败
+func (f *FilebeatPointer) exited() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.running = false
+	f.exits = append(f.exits, time.Now())
+	if len(f.exits) > maxExits {
+		f.exits = f.exits[len(f.exits)-maxExits:]
+	}
+}
+
+// Exits returns the number of collector exits since the given time
+func (f *FilebeatPointer) Exits(since time.Time) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int
+	for _, t := range f.exits {
+		if t.After(since) {
+			n++
+		}
 	}
-	defer file.Close()
+	return n
+}
+
+// Status returns whether the collector is running, when it was last started and how many times it was restarted
+func (f *FilebeatPointer) Status() (bool, time.Time, uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.running, f.started, f.restarts
+}
 
-	decoder := json.NewDecoder(file)
-	var state RegistryState
-	err = decoder.Decode(&state)
+// GetRegistryState 获取 filebeat 仓库中容器日志的基本信息, 键为文件路径
+//
+// 同一路径存在多个状态时(文件轮转), 取最近更新的状态.
+func (f *FilebeatPointer) GetRegistryState() (map[string]RegistryState, error) {
+	states, _, err := f.readRegistry()
 	if err != nil {
 		return nil, err
 	}
 
-	statesMap := make(map[string]RegistryState, 0)
-	if _, ok := statesMap[state.V.Source]; !ok {
+	statesMap := make(map[string]RegistryState, len(states))
+	for _, state := range states {
+		if old, ok := statesMap[state.V.Source]; ok && old.V.Updated().After(state.V.Updated()) {
+			continue
+		}
 		statesMap[state.V.Source] = state
 	}
-
 	return statesMap, nil
 }
 
 // LoadConfigPaths 加载容器config, path
-func (f FilebeatPointer) LoadConfigPaths() map[string]string {
+func (f *FilebeatPointer) LoadConfigPaths() map[string]string {
 	paths := make(map[string]string, 0)
 	// 读取 inputs.d 目录下所有配置
-	confs, _ := ioutil.ReadDir(FilebeatConfDir)
+	confs, _ := ioutil.ReadDir(f.GetConfHome())
 	for _, conf := range confs {
+		// 命名输出的配置目录及临时文件
+		if conf.IsDir() || !strings.HasSuffix(conf.Name(), ".yml") {
+			continue
+		}
+
 		// get file name
-		container := strings.TrimRight(conf.Name(), ".yml")
-		config, err := f.ParseConfig(container)
-		if err != nil || config == nil {
+		container := strings.TrimSuffix(conf.Name(), ".yml")
+		configs, err := f.ParseConfig(container)
+		if err != nil {
 			continue
 		}
 
-		for _, path := range config.Paths {
-			if _, ok := paths[path]; !ok {
-				paths[path] = container
+		for _, config := range configs {
+			for _, path := range config.Paths {
+				if _, ok := paths[path]; !ok {
+					paths[path] = container
+				}
 			}
 		}
 	}
@@ -117,6 +266,7 @@ func (f FilebeatPointer) LoadConfigPaths() map[string]string {
 }
 
 type Config struct {
+	Type  string   `config:"type"`
 	Paths []string `config:"paths"`
 }
 
@@ -126,8 +276,8 @@ var configOpts = []ucfg.Option{
 	ucfg.VarExp,
 }
 
-// ParseConfig 解析容器信息配置，获取path信息
-func (f FilebeatPointer) ParseConfig(container string) (*Config, error) {
+// ParseConfig 解析容器信息配置，获取path信息, 每个 input 对应一项
+func (f *FilebeatPointer) ParseConfig(container string) ([]Config, error) {
 	// get config full path, /etc/filebeat/inputs.d/*.yml
 	confPath := f.GetConfPath(container)
 	c, err := yaml.NewConfigWithFile(confPath, configOpts...)
@@ -136,16 +286,46 @@ func (f FilebeatPointer) ParseConfig(container string) (*Config, error) {
 		return nil, err
 	}
 
-	var config Config
-	if err := c.Unpack(&config); err != nil {
+	var configs []Config
+	if err := c.Unpack(&configs); err != nil {
 		logc.Errorf(context.Background(), "parse %s.yml log config error: %v", container, err)
 		return nil, err
 	}
-	return &config, nil
+	return configs, nil
+}
+
+// ValidateConfig 校验渲染的采集配置, 采集器能够解析且每个 input 的 type 及 paths 不为空
+func ValidateConfig(data []byte) error {
+	c, err := yaml.NewConfig(data, configOpts...)
+	if err != nil {
+		return fmt.Errorf("invalid yaml: %s", err.Error())
+	}
+
+	var inputs []Config
+	if err := c.Unpack(&inputs); err != nil {
+		return fmt.Errorf("invalid inputs: %s", err.Error())
+	}
+	if len(inputs) == 0 {
+		return fmt.Errorf("no inputs rendered")
+	}
+	for i, input := range inputs {
+		if input.Type == "" {
+			return fmt.Errorf("input %d: type is required", i)
+		}
+		if len(input.Paths) == 0 {
+			return fmt.Errorf("input %d: paths is required", i)
+		}
+		for _, path := range input.Paths {
+			if !filepath.IsAbs(path) {
+				return fmt.Errorf("input %d: path %q must be absolute", i, path)
+			}
+		}
+	}
+	return nil
 }
 
 // RenderLogConfig 生成日志采集配置文件
-func (f FilebeatPointer) RenderLogConfig(containerId string, container map[string]string, configList []logtypes.LogConfig) (string, error) {
+func (f *FilebeatPointer) RenderLogConfig(containerId string, container map[string]string, configList []logtypes.LogConfig) (string, error) {
 	for _, config := range configList {
 		logc.Infof(context.Background(), "logs: %s = %v", containerId, config)
 	}
@@ -157,6 +337,10 @@ func (f FilebeatPointer) RenderLogConfig(containerId string, container map[strin
 		"container":   container,
 		"output":      "FILEBEAT_OUTPUT",
 	}
+	// 重新采集后使用新的 input ID
+	if epoch := f.Epoch(containerId); epoch > 0 {
+		m["inputId"] = fmt.Sprintf("%s-%d", containerId, epoch)
+	}
 	if err := f.Tmpl.Execute(&buf, m); err != nil {
 		return "", err
 	}
@@ -164,33 +348,106 @@ func (f FilebeatPointer) RenderLogConfig(containerId string, container map[strin
 	return buf.String(), nil
 }
 
-// CleanConfigs 清理旧配置
-func (f FilebeatPointer) CleanConfigs() error {
-	confDir := f.GetConfHome()
-	d, err := os.Open(confDir)
-	if err != nil {
-		return err
+// filebeatStats 采集器状态接口 /stats 中用到的字段
+type filebeatStats struct {
+	Libbeat struct {
+		Pipeline struct {
+			Events struct {
+				// Filtered 被处理器丢弃的事件, 即 _sample 采样
+				Filtered uint64 `json:"filtered"`
+			} `json:"events"`
+		} `json:"pipeline"`
+	} `json:"libbeat"`
+}
+
+// FilteredEvents 返回采集器启动后被处理器丢弃的事件数, 读取采集器监听在 unix socket 上的状态接口
+func (f *FilebeatPointer) FilteredEvents() (uint64, error) {
+	return readFilteredEvents(f.GetStatsSocket())
+}
+
+func readFilteredEvents(socket string) (uint64, error) {
+	client := &http.Client{
+		Timeout: 2 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
 	}
-	defer d.Close()
+	defer client.CloseIdleConnections()
 
-	// 获取目录下所有数据, 包括目录和文件
-	names, err := d.Readdirnames(-1)
+	resp, err := client.Get("http://filebeat/stats")
 	if err != nil {
-		return err
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("read collector stats failed, status: %s", resp.Status)
+	}
+	var stats filebeatStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return 0, fmt.Errorf("decode collector stats failed, err: %s", err.Error())
 	}
+	return stats.Libbeat.Pipeline.Events.Filtered, nil
+}
 
-	for _, name := range names {
-		conf := filepath.Join(confDir, name)
-		stat, err := os.Stat(filepath.Join(confDir, name))
-		if err != nil {
-			return err
+// QueueStats 统计磁盘队列中未确认的段文件, 返回字节数, 段文件数及最早段文件的写入时间, 未开启磁盘队列时返回 0
+func (f *FilebeatPointer) QueueStats() (int64, int, time.Time, error) {
+	var (
+		size     int64
+		segments int
+		oldest   time.Time
+	)
+	entries, err := ioutil.ReadDir(f.GetQueuePath())
+	if os.IsNotExist(err) {
+		return 0, 0, oldest, nil
+	}
+	if err != nil {
+		return 0, 0, oldest, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".seg" {
+			continue
 		}
-		// 是否为普通文件
-		if stat.Mode().IsRegular() {
-			if err := os.Remove(conf); err != nil {
-				return err
+		size += e.Size()
+		segments++
+		if oldest.IsZero() || e.ModTime().Before(oldest) {
+			oldest = e.ModTime()
+		}
+	}
+	return size, segments, oldest, nil
+}
+
+// Unshipped 返回容器日志文件中采集器尚未读取的字节数, 键为文件路径, 已读取完的文件不返回
+func (f *FilebeatPointer) Unshipped(container string) (map[string]int64, error) {
+	if _, err := os.Stat(f.GetConfPath(container)); os.IsNotExist(err) {
+		return nil, nil
+	}
+	configs, err := f.ParseConfig(container)
+	if err != nil {
+		return nil, err
+	}
+
+	states, err := f.GetRegistryState()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	pending := make(map[string]int64)
+	for _, config := range configs {
+		for _, pattern := range config.Paths {
+			paths, _ := filepath.Glob(pattern)
+			for _, path := range paths {
+				info, err := os.Stat(path)
+				if err != nil || !info.Mode().IsRegular() {
+					continue
+				}
+				if offset := states[path].V.Offset; offset < info.Size() {
+					pending[path] = info.Size() - offset
+				}
 			}
 		}
 	}
-	return nil
+	return pending, nil
 }
@@ -0,0 +1,37 @@
+package provider
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadFilteredEvents(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "filebeat.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"beat":{"uptime":{"ms":1000}},"libbeat":{"pipeline":{"events":{"active":3,"filtered":42,"published":100}}}}`))
+	}))
+	srv.Listener = l
+	srv.Start()
+	defer srv.Close()
+
+	filtered, err := readFilteredEvents(socket)
+	if err != nil || filtered != 42 {
+		t.Fatalf("readFilteredEvents = %d, %v, want 42", filtered, err)
+	}
+
+	// 采集器未运行时 socket 不存在
+	if _, err := readFilteredEvents(filepath.Join(t.TempDir(), "missing.sock")); err == nil {
+		t.Error("readFilteredEvents on a missing socket succeeded")
+	}
+}
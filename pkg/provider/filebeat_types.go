@@ -3,6 +3,7 @@ package provider
 import (
 	"fmt"
 	"time"
+	logtypes "watchlog/log/config"
 )
 
 // RegistryState represents log offsets
@@ -12,14 +13,29 @@ type RegistryState struct {
 }
 
 type RegistryV struct {
-	Source      string        `json:"source"`
-	Offset      int64         `json:"offset"`
-	Timestamp   []time.Time   `json:"timestamp"`
+	Source string `json:"source"`
+	Offset int64  `json:"offset"`
+	// Timestamp 采集器编码的更新时间, 第二个元素为秒级时间戳
+	Timestamp   []int64       `json:"timestamp"`
 	TTL         time.Duration `json:"ttl"`
 	Type        string        `json:"type"`
 	FileStateOS FileInode
 }
 
+// Updated returns the last time the state was updated
+func (v RegistryV) Updated() time.Time {
+	if len(v.Timestamp) < 2 {
+		return time.Time{}
+	}
+	return time.Unix(v.Timestamp[1], 0)
+}
+
+// registryOp 仓库操作日志, 每个操作后跟一行对应的状态
+type registryOp struct {
+	Op string `json:"op"`
+	ID uint64 `json:"id"`
+}
+
 type FileInode struct {
 	Inode  uint64 `json:"inode,"`
 	Device uint64 `json:"device,"`
@@ -30,20 +46,68 @@ const (
 	FilebeatExecCmd  = FilebeatBaseConf + "/filebeat"
 	FilebeatConfFile = FilebeatBaseConf + "/filebeat.yml"
 	FilebeatConfDir  = FilebeatBaseConf + "/inputs.d"
-	FilebeatRegistry = FilebeatBaseConf + "/data/registry/filebeat/log.json"
+	// FilebeatDataDir 采集器的数据目录, 需要挂载宿主机目录, 重建 Pod 后保留仓库中的采集进度及磁盘队列
+	FilebeatDataDir  = "/var/lib/filebeat"
+	FilebeatRegistry = FilebeatDataDir + "/" + logtypes.DefaultOutput + "/registry/filebeat/log.json"
+	// WatchlogDataDir WatchLog 自身的状态目录, 需要挂载宿主机目录, 重建 Pod 后保留
+	WatchlogDataDir = "/var/lib/watchlog"
 )
 
 // GetConfPath get configuration path FilebeatConfDir/${container}.yaml
-func (f FilebeatPointer) GetConfPath(container string) string {
-	return fmt.Sprintf("%s/%s.yml", FilebeatConfDir, container)
+func (f *FilebeatPointer) GetConfPath(container string) string {
+	return fmt.Sprintf("%s/%s.yml", f.GetConfHome(), container)
 }
 
 // GetBaseConf returns plugin root directory
-func (f FilebeatPointer) GetBaseConf() string {
+func (f *FilebeatPointer) GetBaseConf() string {
 	return FilebeatBaseConf
 }
 
-// GetConfHome returns configuration directory
-func (f FilebeatPointer) GetConfHome() string {
-	return FilebeatConfDir
+// GetConfHome returns configuration directory, named outputs use FilebeatConfDir/${output}
+func (f *FilebeatPointer) GetConfHome() string {
+	if f.Output == logtypes.DefaultOutput {
+		return f.root + FilebeatConfDir
+	}
+	return fmt.Sprintf("%s%s/%s", f.root, FilebeatConfDir, f.Output)
+}
+
+// GetConfFile returns the collector base configuration file
+func (f *FilebeatPointer) GetConfFile() string {
+	if f.Output == logtypes.DefaultOutput {
+		return FilebeatConfFile
+	}
+	return fmt.Sprintf("%s/filebeat-%s.yml", FilebeatBaseConf, f.Output)
+}
+
+// GetDataPath returns the collector data directory FilebeatDataDir/${output}, each instance needs its own
+func (f *FilebeatPointer) GetDataPath() string {
+	return fmt.Sprintf("%s%s/%s", f.root, FilebeatDataDir, f.Output)
+}
+
+// GetLogsPath returns the collector logs directory
+func (f *FilebeatPointer) GetLogsPath() string {
+	if f.Output == logtypes.DefaultOutput {
+		return FilebeatBaseConf + "/logs"
+	}
+	return fmt.Sprintf("%s/logs-%s", FilebeatBaseConf, f.Output)
+}
+
+// GetRegistry returns the collector registry file
+func (f *FilebeatPointer) GetRegistry() string {
+	return f.GetDataPath() + "/registry/filebeat/log.json"
+}
+
+// GetQueuePath returns the collector disk queue directory
+func (f *FilebeatPointer) GetQueuePath() string {
+	return f.GetDataPath() + "/diskqueue"
+}
+
+// GetStatsSocket returns the unix socket of the collector HTTP endpoint
+func (f *FilebeatPointer) GetStatsSocket() string {
+	return f.GetDataPath() + "/filebeat.sock"
+}
+
+// GetIndexPath returns the file recording the generation of each container config
+func (f *FilebeatPointer) GetIndexPath() string {
+	return fmt.Sprintf("%s%s/configs/%s.json", f.root, WatchlogDataDir, f.Output)
 }
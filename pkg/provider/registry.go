@@ -0,0 +1,222 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// registryCheckpoint 检查点文件中的状态, _key 与状态字段位于同一对象
+type registryCheckpoint struct {
+	Key string `json:"_key"`
+	RegistryV
+}
+
+// FileProgress 日志文件的采集进度
+type FileProgress struct {
+	Container string
+	Namespace string
+	Path      string
+	Size      int64
+	Offset    int64
+	// Updated 采集器最后一次更新进度的时间, 没有进度时为零值
+	Updated time.Time
+}
+
+// GetRegistryHome returns the collector registry directory
+func (f *FilebeatPointer) GetRegistryHome() string {
+	return filepath.Dir(f.GetRegistry())
+}
+
+// readRegistry 读取采集器仓库, 键为状态的 key, 同时返回最后一个操作的 id
+//
+// 与采集器一致, 先加载 active.dat 指向的检查点 {txid}.json, 再回放 log.json 中 id 大于 txid 的操作.
+// 每个操作后跟一行状态, set 更新状态, remove 删除状态.
+func (f *FilebeatPointer) readRegistry() (map[string]RegistryState, uint64, error) {
+	states := make(map[string]RegistryState)
+	txid, err := f.readCheckpoint(states)
+	if err != nil {
+		return nil, 0, err
+	}
+	last := txid
+
+	file, err := os.Open(f.GetRegistry())
+	if os.IsNotExist(err) {
+		return states, last, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	for {
+		var op registryOp
+		if err := decoder.Decode(&op); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, 0, err
+		}
+		var state RegistryState
+		if err := decoder.Decode(&state); err != nil {
+			// 采集器正在写入的最后一行不完整
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, 0, err
+		}
+		if op.ID > last {
+			last = op.ID
+		}
+		if op.ID <= txid {
+			continue
+		}
+
+		switch op.Op {
+		case "set":
+			states[state.K] = state
+		case "remove":
+			delete(states, state.K)
+		}
+	}
+	return states, last, nil
+}
+
+// ForgetFiles 从采集器仓库删除日志文件的状态, 返回删除的状态数, 只能在采集器停止时调用
+//
+// 在 log.json 末尾追加 remove 操作, 操作 id 接续最后一个操作, 采集器启动时回放删除.
+func (f *FilebeatPointer) ForgetFiles(paths []string) (int, error) {
+	states, last, err := f.readRegistry()
+	if err != nil {
+		return 0, err
+	}
+	forget := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		forget[path] = true
+	}
+
+	var buf bytes.Buffer
+	var keys []string
+	for key, state := range states {
+		if forget[state.V.Source] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		last++
+		op, _ := json.Marshal(registryOp{Op: "remove", ID: last})
+		k, _ := json.Marshal(map[string]string{"k": key})
+		buf.Write(op)
+		buf.WriteByte('\n')
+		buf.Write(k)
+		buf.WriteByte('\n')
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	file, err := os.OpenFile(f.GetRegistry(), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	// 丢弃末尾不完整的操作, 操作及状态各占一行, 只保留完整的两行
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return 0, err
+	}
+	var end, lines int64
+	for i, b := range data {
+		if b != '\n' {
+			continue
+		}
+		if lines++; lines%2 == 0 {
+			end = int64(i + 1)
+		}
+	}
+	if err := file.Truncate(end); err != nil {
+		return 0, err
+	}
+	if _, err := file.WriteAt(buf.Bytes(), end); err != nil {
+		return 0, err
+	}
+	return len(keys), file.Sync()
+}
+
+// readCheckpoint 加载 active.dat 指向的检查点, 返回检查点的 txid, 没有检查点时返回 0
+func (f *FilebeatPointer) readCheckpoint(states map[string]RegistryState) (uint64, error) {
+	active, err := ioutil.ReadFile(filepath.Join(f.GetRegistryHome(), "active.dat"))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	name := filepath.Base(strings.TrimSpace(string(active)))
+	if name == "." || name == "/" {
+		return 0, nil
+	}
+	txid, err := strconv.ParseUint(strings.TrimSuffix(name, ".json"), 10, 64)
+	if err != nil {
+		return 0, nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(f.GetRegistryHome(), name))
+	if err != nil {
+		return 0, err
+	}
+	var entries []registryCheckpoint
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return 0, err
+	}
+	for _, e := range entries {
+		states[e.Key] = RegistryState{K: e.Key, V: e.RegistryV}
+	}
+	return txid, nil
+}
+
+// Progress 按容器配置展开日志文件, 返回每个文件的大小及采集进度
+func (f *FilebeatPointer) Progress() ([]FileProgress, error) {
+	states, err := f.GetRegistryState()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	namespaces := make(map[string]string)
+	for _, g := range f.Generations() {
+		namespaces[g.Container] = g.Namespace
+	}
+
+	var ret []FileProgress
+	seen := make(map[string]bool)
+	for pattern, container := range f.LoadConfigPaths() {
+		paths, _ := filepath.Glob(pattern)
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() || seen[path] {
+				continue
+			}
+			seen[path] = true
+
+			state := states[path]
+			ret = append(ret, FileProgress{
+				Container: container,
+				Namespace: namespaces[container],
+				Path:      path,
+				Size:      info.Size(),
+				Offset:    state.V.Offset,
+				Updated:   state.V.Updated(),
+			})
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,246 @@
+package provider
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestPointer 创建配置及数据目录位于临时目录的采集器
+func newTestPointer(t *testing.T) *FilebeatPointer {
+	t.Helper()
+	f := NewFilebeatPointer(nil, "", "default")
+	f.root = t.TempDir()
+	for _, dir := range []string{f.GetConfHome(), f.GetRegistryHome()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+// stateLine 仓库中的一行状态, updated 为秒级时间戳
+func stateLine(key, source string, offset, updated int64) string {
+	return fmt.Sprintf(`{"k":%q,"v":{"source":%q,"offset":%d,"timestamp":[2061634,%d],"ttl":-1,"type":"container","FileStateOS":{"inode":1,"device":2}}}`,
+		key, source, offset, updated)
+}
+
+func opLine(op string, id int) string {
+	return fmt.Sprintf(`{"op":%q,"id":%d}`, op, id)
+}
+
+// writeRegistry 写入检查点 {txid}.json 及 active.dat, 以及操作日志 log.json
+func writeRegistry(t *testing.T, f *FilebeatPointer, txid int, checkpoint []string, log string) {
+	t.Helper()
+	home := f.GetRegistryHome()
+	if checkpoint != nil {
+		var entries []string
+		for _, line := range checkpoint {
+			// 检查点中 _key 与状态字段位于同一对象
+			var s RegistryState
+			if err := json.Unmarshal([]byte(line), &s); err != nil {
+				t.Fatal(err)
+			}
+			v, _ := json.Marshal(s.V)
+			entries = append(entries, fmt.Sprintf(`{"_key":%q,%s`, s.K, v[1:]))
+		}
+		name := filepath.Join(home, fmt.Sprintf("%d.json", txid))
+		if err := ioutil.WriteFile(name, []byte("["+strings.Join(entries, ",")+"]"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(home, "active.dat"), []byte(name+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(f.GetRegistry(), []byte(log), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testRegistry 检查点 txid 为 5, 操作日志包含检查点之前的操作, 轮转及末尾不完整的一行
+func testRegistry(t *testing.T, f *FilebeatPointer) {
+	writeRegistry(t, f, 5, []string{
+		stateLine("a", "/logs/a.log", 10, 1700000000),
+		stateLine("b", "/logs/b.log", 20, 1700000000),
+	}, strings.Join([]string{
+		// id 不大于 txid 的操作已包含在检查点中
+		opLine("set", 4), stateLine("a", "/logs/a.log", 5, 1699999999),
+		opLine("set", 6), stateLine("a", "/logs/a.log", 30, 1700000010),
+		opLine("set", 7), stateLine("c-1", "/logs/c.log", 100, 1700000001),
+		opLine("remove", 8), `{"k":"b"}`,
+		// c.log 轮转后新文件的状态
+		opLine("set", 9), stateLine("c-2", "/logs/c.log", 7, 1700000020),
+		opLine("set", 10), `{"k":"d","v":{"sou`,
+	}, "\n"))
+}
+
+func TestReadRegistry(t *testing.T) {
+	f := newTestPointer(t)
+	testRegistry(t, f)
+
+	states, last, err := f.readRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 不完整的操作不计入
+	if last != 9 {
+		t.Errorf("last op id = %d, want 9", last)
+	}
+	offsets := make(map[string]int64)
+	for k, s := range states {
+		offsets[k] = s.V.Offset
+	}
+	if want := map[string]int64{"a": 30, "c-1": 100, "c-2": 7}; !reflect.DeepEqual(offsets, want) {
+		t.Errorf("offsets = %v, want %v", offsets, want)
+	}
+
+	// 同一路径取最近更新的状态
+	bySource, err := f.GetRegistryState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := bySource["/logs/c.log"]; s.K != "c-2" || s.V.Offset != 7 || !s.V.Updated().Equal(time.Unix(1700000020, 0)) {
+		t.Errorf("c.log state = %+v, want the rotated c-2", s)
+	}
+	if _, ok := bySource["/logs/b.log"]; ok {
+		t.Error("removed b.log is still in the registry")
+	}
+}
+
+func TestReadRegistryWithoutCheckpoint(t *testing.T) {
+	f := newTestPointer(t)
+	// 没有检查点时回放全部操作
+	writeRegistry(t, f, 0, nil, strings.Join([]string{
+		opLine("set", 1), stateLine("a", "/logs/a.log", 5, 1700000000),
+		opLine("set", 2), stateLine("a", "/logs/a.log", 8, 1700000001),
+		"",
+	}, "\n"))
+	states, last, err := f.readRegistry()
+	if err != nil || last != 2 || states["a"].V.Offset != 8 {
+		t.Errorf("readRegistry = %v, %d, %v, want a at 8 and last 2", states, last, err)
+	}
+
+	// 采集器尚未写入仓库
+	empty := newTestPointer(t)
+	if states, last, err := empty.readRegistry(); err != nil || len(states) != 0 || last != 0 {
+		t.Errorf("readRegistry of an empty registry = %v, %d, %v", states, last, err)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	f := newTestPointer(t)
+	logs := filepath.Join(f.root, "logs")
+	if err := os.MkdirAll(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, size := range map[string]int{"a.log": 30, "c.log": 50} {
+		if err := ioutil.WriteFile(filepath.Join(logs, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeRegistry(t, f, 0, nil, strings.Join([]string{
+		opLine("set", 1), stateLine("a", filepath.Join(logs, "a.log"), 30, 1700000000),
+		opLine("set", 2), stateLine("c-1", filepath.Join(logs, "c.log"), 45, 1700000001),
+		opLine("set", 3), stateLine("c-2", filepath.Join(logs, "c.log"), 20, 1700000002),
+		"",
+	}, "\n"))
+	config := fmt.Sprintf("- type: log\n  paths:\n    - %s/*.log\n", logs)
+	if _, err := f.WriteConfig("c1", "prod", []byte(config)); err != nil {
+		t.Fatal(err)
+	}
+
+	progress, err := f.Progress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(progress, func(i, j int) bool { return progress[i].Path < progress[j].Path })
+	want := []FileProgress{
+		{Container: "c1", Namespace: "prod", Path: filepath.Join(logs, "a.log"), Size: 30, Offset: 30, Updated: time.Unix(1700000000, 0)},
+		{Container: "c1", Namespace: "prod", Path: filepath.Join(logs, "c.log"), Size: 50, Offset: 20, Updated: time.Unix(1700000002, 0)},
+	}
+	if !reflect.DeepEqual(progress, want) {
+		t.Errorf("Progress = %+v, want %+v", progress, want)
+	}
+
+	// 已读取完的文件不返回
+	pending, err := f.Unshipped("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int64{filepath.Join(logs, "c.log"): 30}; !reflect.DeepEqual(pending, want) {
+		t.Errorf("Unshipped = %v, want %v", pending, want)
+	}
+	if pending, err := f.Unshipped("missing"); pending != nil || err != nil {
+		t.Errorf("Unshipped of a container without config = %v, %v", pending, err)
+	}
+}
+
+func TestForgetFiles(t *testing.T) {
+	f := newTestPointer(t)
+	testRegistry(t, f)
+
+	n, err := f.ForgetFiles([]string{"/logs/c.log", "/logs/missing.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 轮转前后的两个状态都删除
+	if n != 2 {
+		t.Errorf("ForgetFiles removed %d states, want 2", n)
+	}
+	states, last, err := f.readRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := states["a"]; !ok || len(states) != 1 {
+		t.Errorf("states after ForgetFiles = %v, want only a", states)
+	}
+	if last != 11 {
+		t.Errorf("last op id = %d, want 11", last)
+	}
+
+	// 不完整的一行被丢弃, 每个操作后跟一行状态, 追加的操作 id 接续
+	file, err := os.Open(f.GetRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines)%2 != 0 {
+		t.Fatalf("registry has %d lines, want op and state pairs:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	var ids []uint64
+	for i := 0; i < len(lines); i += 2 {
+		var op registryOp
+		if err := json.Unmarshal([]byte(lines[i]), &op); err != nil {
+			t.Fatalf("line %d is not an op: %s", i+1, lines[i])
+		}
+		ids = append(ids, op.ID)
+	}
+	if want := []uint64{4, 6, 7, 8, 9, 10, 11}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("op ids = %v, want %v", ids, want)
+	}
+	if tail := strings.Join(lines[len(lines)-4:], "\n"); tail != opLine("remove", 10)+"\n"+`{"k":"c-1"}`+"\n"+opLine("remove", 11)+"\n"+`{"k":"c-2"}` {
+		t.Errorf("appended ops:\n%s", tail)
+	}
+
+	// 没有需要删除的状态时不改写仓库
+	before, _ := ioutil.ReadFile(f.GetRegistry())
+	if n, err := f.ForgetFiles([]string{"/logs/c.log"}); n != 0 || err != nil {
+		t.Errorf("ForgetFiles again = %d, %v", n, err)
+	}
+	if after, _ := ioutil.ReadFile(f.GetRegistry()); string(after) != string(before) {
+		t.Error("ForgetFiles without matching states rewrote the registry")
+	}
+}
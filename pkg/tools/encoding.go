@@ -0,0 +1,133 @@
+package tools
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// encodings supported log file encodings, names follow the filebeat encoding option
+var encodings = map[string]encoding.Encoding{
+	"plain":        nil,
+	"utf-8":        nil,
+	"latin1":       charmap.ISO8859_1,
+	"iso8859-1":    charmap.ISO8859_1,
+	"iso8859-15":   charmap.ISO8859_15,
+	"windows1250":  charmap.Windows1250,
+	"windows1251":  charmap.Windows1251,
+	"windows1252":  charmap.Windows1252,
+	"utf-16be-bom": unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM),
+	"utf-16be":     unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+	"utf-16le":     unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+	"gbk":          simplifiedchinese.GBK,
+	"gb18030":      simplifiedchinese.GB18030,
+	"hz-gb-2312":   simplifiedchinese.HZGB2312,
+	"big5":         traditionalchinese.Big5,
+	"euc-kr":       korean.EUCKR,
+	"euc-jp":       japanese.EUCJP,
+	"iso-2022-jp":  japanese.ISO2022JP,
+	"shift-jis":    japanese.ShiftJIS,
+}
+
+// ValidateEncoding checks the encoding is in the supported list
+func ValidateEncoding(name string) error {
+	if _, ok := encodings[name]; !ok {
+		var names []string
+		for n := range encodings {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unsupported encoding: %s, supported: %s", name, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// Transcoder converts log lines of an encoding to UTF-8.
+// Invalid byte sequences are replaced with U+FFFD.
+type Transcoder struct {
+	enc encoding.Encoding
+	// replacement 源编码中的 U+FFFD, 无法表示时为 nil, 解码得到的原有 U+FFFD 不是非法序列
+	replacement []byte
+	// unit 源编码的码元字节数, 查找 replacement 时按码元对齐
+	unit int
+}
+
+// NewTranscoder creates a transcoder for a supported encoding
+func NewTranscoder(name string) (*Transcoder, error) {
+	if err := ValidateEncoding(name); err != nil {
+		return nil, err
+	}
+	t := &Transcoder{enc: encodings[name], unit: 1}
+	if t.enc == nil {
+		return t, nil
+	}
+	if strings.HasPrefix(name, "utf-16") {
+		t.unit = 2
+	}
+	// 编码 "a" 及 "a\uFFFD" 的差即为 U+FFFD, 去除编码器可能写入的 BOM
+	prefix, err1 := t.enc.NewEncoder().Bytes([]byte("a"))
+	full, err2 := t.enc.NewEncoder().Bytes([]byte("a\uFFFD"))
+	if err1 == nil && err2 == nil && bytes.HasPrefix(full, prefix) && len(full) > len(prefix) {
+		t.replacement = full[len(prefix):]
+	}
+	return t, nil
+}
+
+// Decode returns line as a valid UTF-8 string and the number of invalid byte sequences replaced
+func (t *Transcoder) Decode(line []byte) (string, int) {
+	if t.enc != nil {
+		decoded, err := t.enc.NewDecoder().Bytes(line)
+		if err == nil {
+			// 解码器将非法字节序列替换为 U+FFFD, 扣除源数据中原有的 U+FFFD
+			n := bytes.Count(decoded, []byte(string(utf8.RuneError)))
+			if n > 0 && t.replacement != nil {
+				n -= t.count(line)
+			}
+			if n < 0 {
+				n = 0
+			}
+			return string(decoded), n
+		}
+		// 无法解码时按 UTF-8 处理, 非法字节同样被替换
+	}
+
+	var (
+		buf     strings.Builder
+		invalid int
+	)
+	buf.Grow(len(line))
+	for len(line) > 0 {
+		// 原有的 U+FFFD 长度为 3, 只有长度为 1 的 RuneError 是非法字节
+		r, size := utf8.DecodeRune(line)
+		if r == utf8.RuneError && size == 1 {
+			invalid++
+		}
+		buf.WriteRune(r)
+		line = line[size:]
+	}
+	return buf.String(), invalid
+}
+
+// count 按码元对齐统计源数据中的 U+FFFD
+func (t *Transcoder) count(line []byte) int {
+	var n int
+	for i := 0; i+len(t.replacement) <= len(line); {
+		if bytes.HasPrefix(line[i:], t.replacement) {
+			n++
+			i += len(t.replacement)
+			continue
+		}
+		i += t.unit
+	}
+	return n
+}
@@ -0,0 +1,34 @@
+package tools
+
+import "testing"
+
+func TestTranscoderInvalid(t *testing.T) {
+	cases := []struct {
+		encoding string
+		in       []byte
+		want     string
+		invalid  int
+	}{
+		{"utf-8", []byte("ok"), "ok", 0},
+		{"utf-8", []byte("a\xffb\xfe"), "a�b�", 2},
+		// 日志中原有的 U+FFFD 不是非法序列
+		{"utf-8", []byte("a�b"), "a�b", 0},
+		{"gbk", []byte("\xc4\xe3\xba\xc3"), "你好", 0},
+		{"gbk", []byte("\xc4\xe3\xff"), "你�", 1},
+		{"utf-16le", []byte("a\x00\xfd\xff"), "a�", 0},
+		{"utf-16le", []byte("a\x00\x00\xd8b\x00"), "a�b", 1},
+		{"utf-16be", []byte("\xff\xfd\xd8\x00\x00a"), "��a", 1},
+		{"gb18030", []byte("\x84\x31\xa4\x37x"), "�x", 0},
+		{"latin1", []byte("caf\xe9"), "café", 0},
+	}
+	for _, c := range cases {
+		tc, err := NewTranscoder(c.encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, invalid := tc.Decode(c.in)
+		if got != c.want || invalid != c.invalid {
+			t.Errorf("%s: Decode(%q) = %q, %d, want %q, %d", c.encoding, c.in, got, invalid, c.want, c.invalid)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package tools
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Env 读取输出配置的环境变量并收集错误
+//
+// 任意变量 X 均可以通过 X_FILE 指定从文件读取, 用于挂载的 secret.
+type Env struct {
+	Prefix string
+	errs   []string
+}
+
+// NewEnv creates an env reader, prefix is prepended to every key, e.g. AUDIT_ for the audit output
+func NewEnv(prefix string) *Env {
+	return &Env{Prefix: prefix}
+}
+
+// Errorf records a config error
+func (r *Env) Errorf(format string, args ...interface{}) {
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+// Err returns all recorded config errors
+func (r *Env) Err() error {
+	if len(r.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid output config: %s", strings.Join(r.errs, "; "))
+}
+
+// Str returns the value of key or the content of the file set by key_FILE
+func (r *Env) Str(key string) string {
+	name := r.Prefix + key
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			r.Errorf("%s_FILE read failed: %s", name, err.Error())
+			return ""
+		}
+		return strings.TrimSpace(string(data))
+	}
+	return os.Getenv(name)
+}
+
+// Required returns the value of key, records an error if empty
+func (r *Env) Required(key string) string {
+	v := r.Str(key)
+	if v == "" {
+		r.Errorf("%s%s required", r.Prefix, key)
+	}
+	return v
+}
+
+// Int returns the non-negative integer value of key
+func (r *Env) Int(key string) int {
+	v := r.Str(key)
+	if v == "" {
+		return 0
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		r.Errorf("%s%s must be a non-negative integer, got %q", r.Prefix, key, v)
+	}
+	return i
+}
+
+// BoolPtr returns the bool value of key, nil if not set
+func (r *Env) BoolPtr(key string) *bool {
+	v := r.Str(key)
+	if v == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		r.Errorf("%s%s must be a bool, got %q", r.Prefix, key, v)
+	}
+	return &b
+}
+
+// Duration returns the duration value of key, e.g. 10s
+func (r *Env) Duration(key string) string {
+	v := r.Str(key)
+	if v == "" {
+		return ""
+	}
+	if _, err := time.ParseDuration(v); err != nil {
+		r.Errorf("%s%s must be a duration, e.g. 10s, got %q", r.Prefix, key, v)
+	}
+	return v
+}
+
+// Size returns the size value of key, e.g. 10GB, 512MiB
+func (r *Env) Size(key string) string {
+	v := r.Str(key)
+	if v == "" {
+		return ""
+	}
+	if _, err := ParseSize(v); err != nil {
+		r.Errorf("%s%s must be a size, e.g. 512MiB, got %q", r.Prefix, key, v)
+	}
+	return v
+}
+
+// List 逗号分隔的列表
+func (r *Env) List(key string) []string {
+	var ret []string
+	for _, v := range strings.Split(r.Str(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// HostPort host + port 组成的地址
+func (r *Env) HostPort(hostKey, portKey string) []string {
+	host, port := r.Required(hostKey), r.Required(portKey)
+	if port != "" {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			r.Errorf("%s%s must be a port number, got %q", r.Prefix, portKey, port)
+		}
+	}
+	if host == "" || port == "" {
+		return nil
+	}
+	return []string{host + ":" + port}
+}
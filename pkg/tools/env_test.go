@@ -0,0 +1,101 @@
+package tools
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(secret, []byte("  s3cr:et#\"x\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("T_KAFKA_USERNAME", "watchlog")
+	t.Setenv("T_KAFKA_PASSWORD", "ignored")
+	// X_FILE 优先于 X, 文件内容去掉首尾空白
+	t.Setenv("T_KAFKA_PASSWORD_FILE", secret)
+	t.Setenv("T_KAFKA_BROKERS_FILE", secret+".brokers")
+	if err := ioutil.WriteFile(secret+".brokers", []byte("a:9092, b:9092,\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewEnv("T_")
+	if got := r.Str("KAFKA_USERNAME"); got != "watchlog" {
+		t.Errorf("Str(KAFKA_USERNAME) = %q, want watchlog", got)
+	}
+	if got := r.Str("KAFKA_PASSWORD"); got != `s3cr:et#"x` {
+		t.Errorf("Str(KAFKA_PASSWORD) = %q, want the file content", got)
+	}
+	if got := r.List("KAFKA_BROKERS"); !reflect.DeepEqual(got, []string{"a:9092", "b:9092"}) {
+		t.Errorf("List(KAFKA_BROKERS) = %v", got)
+	}
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("T_REDIS_PASSWORD_FILE", filepath.Join(dir, "missing"))
+	if got := r.Str("REDIS_PASSWORD"); got != "" {
+		t.Errorf("Str(REDIS_PASSWORD) with a missing file = %q, want empty", got)
+	}
+	if err := r.Err(); err == nil || !strings.Contains(err.Error(), "T_REDIS_PASSWORD_FILE read failed") {
+		t.Errorf("missing secret file err = %v", err)
+	}
+}
+
+func TestEnvValues(t *testing.T) {
+	t.Setenv("T_WORKER", "4")
+	t.Setenv("T_BAD_WORKER", "-1")
+	t.Setenv("T_PRETTY", "true")
+	t.Setenv("T_BAD_PRETTY", "yes please")
+	t.Setenv("T_TIMEOUT", "10s")
+	t.Setenv("T_BAD_TIMEOUT", "10")
+	t.Setenv("T_SIZE", "512MiB")
+	t.Setenv("T_BAD_SIZE", "lots")
+	t.Setenv("T_HOST", "redis")
+	t.Setenv("T_PORT", "6379")
+	t.Setenv("T_BAD_PORT", "70000")
+
+	r := NewEnv("T_")
+	if got := r.Int("WORKER"); got != 4 {
+		t.Errorf("Int(WORKER) = %d, want 4", got)
+	}
+	if got := r.BoolPtr("PRETTY"); got == nil || !*got {
+		t.Errorf("BoolPtr(PRETTY) = %v, want true", got)
+	}
+	if got := r.BoolPtr("UNSET"); got != nil {
+		t.Errorf("BoolPtr(UNSET) = %v, want nil", *got)
+	}
+	if got := r.Duration("TIMEOUT"); got != "10s" {
+		t.Errorf("Duration(TIMEOUT) = %q", got)
+	}
+	if got := r.Size("SIZE"); got != "512MiB" {
+		t.Errorf("Size(SIZE) = %q", got)
+	}
+	if got := r.HostPort("HOST", "PORT"); !reflect.DeepEqual(got, []string{"redis:6379"}) {
+		t.Errorf("HostPort = %v", got)
+	}
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Int("BAD_WORKER")
+	r.BoolPtr("BAD_PRETTY")
+	r.Duration("BAD_TIMEOUT")
+	r.Size("BAD_SIZE")
+	r.HostPort("HOST", "BAD_PORT")
+	r.Required("UNSET")
+	err := r.Err()
+	if err == nil {
+		t.Fatal("invalid values should be reported")
+	}
+	// 全部错误一起返回
+	for _, key := range []string{"T_BAD_WORKER", "T_BAD_PRETTY", "T_BAD_TIMEOUT", "T_BAD_SIZE", "T_BAD_PORT", "T_UNSET required"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("err %q does not mention %s", err.Error(), key)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-ucfg"
+	"github.com/elastic/go-ucfg/yaml"
+)
+
+// MaskPattern 敏感数据的脱敏规则, 匹配的内容整体替换为 Replacement
+//
+// Pattern 同时由 Go 及 Filebeat script 处理器(JavaScript)编译, 只能使用两者共同支持的语法,
+// 例如不支持 (?i) 及 [[:space:]], Validate 时报错.
+type MaskPattern struct {
+	Pattern string `config:"pattern"`
+	// Replacement 替换的文本, 不支持引用分组, 默认为 [MASKED:{name}]
+	Replacement string `config:"replacement"`
+	// Luhn 匹配的数字通过 Luhn 校验时才替换, 用于银行卡号
+	Luhn bool `config:"luhn"`
+}
+
+var maskPatterns = make(map[string]MaskPattern)
+
+// RegisterMask register mask pattern by name, e.g. email for watchlog_{name}_mask=email
+func RegisterMask(name string, pattern MaskPattern) {
+	if pattern.Replacement == "" {
+		pattern.Replacement = "[MASKED:" + name + "]"
+	}
+	maskPatterns[name] = pattern
+}
+
+// GetMask returns the mask pattern registered by name
+func GetMask(name string) (MaskPattern, error) {
+	pattern, ok := maskPatterns[name]
+	if !ok {
+		var names []string
+		for n := range maskPatterns {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return pattern, fmt.Errorf("unsupported mask: %s, supported: %s", name, strings.Join(names, ", "))
+	}
+	return pattern, nil
+}
+
+// LoadMaskPatterns 从 yaml 文件注册自定义的脱敏规则, 同名时覆盖内置规则
+//
+//	idcard:
+//	  pattern: '\b\d{17}[\dXx]\b'
+//	  replacement: '[MASKED:idcard]'
+func LoadMaskPatterns(path string) error {
+	c, err := yaml.NewConfigWithFile(path, ucfg.PathSep("."))
+	if err != nil {
+		return fmt.Errorf("read mask patterns %s failed, err: %s", path, err.Error())
+	}
+
+	// Unpack 会调用 MaskPattern.Validate 校验每个规则
+	patterns := make(map[string]MaskPattern)
+	if err := c.Unpack(&patterns); err != nil {
+		return fmt.Errorf("parse mask patterns %s failed, err: %s", path, err.Error())
+	}
+	for name, pattern := range patterns {
+		RegisterMask(name, pattern)
+	}
+	return nil
+}
+
+// Validate checks the pattern compiles and only uses the syntax shared by Go and JavaScript
+func (p MaskPattern) Validate() error {
+	if p.Pattern == "" {
+		return fmt.Errorf("pattern can not be empty")
+	}
+	if _, err := regexp.Compile(p.Pattern); err != nil {
+		return fmt.Errorf("invalid pattern %q: %s", p.Pattern, err.Error())
+	}
+	return ValidateScriptRegexp(p.Pattern)
+}
+
+// Masker 按顺序应用多个脱敏规则
+type Masker struct {
+	patterns []MaskPattern
+	compiled []*regexp.Regexp
+}
+
+// NewMasker creates a masker of the named patterns, returns nil if names is empty
+func NewMasker(names []string) (*Masker, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	m := &Masker{}
+	for _, name := range names {
+		pattern, err := GetMask(name)
+		if err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(pattern.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("mask %s: %s", name, err.Error())
+		}
+		m.patterns = append(m.patterns, pattern)
+		m.compiled = append(m.compiled, re)
+	}
+	return m, nil
+}
+
+// Mask replaces the sensitive data in s
+func (m *Masker) Mask(s string) string {
+	for i, re := range m.compiled {
+		p := m.patterns[i]
+		s = re.ReplaceAllStringFunc(s, func(match string) string {
+			if p.Luhn && !luhn(match) {
+				return match
+			}
+			return p.Replacement
+		})
+	}
+	return s
+}
+
+// Script returns the source of the Filebeat script processor applying the same patterns to message
+func (m *Masker) Script() string {
+	var rules []string
+	for _, p := range m.patterns {
+		pattern, _ := json.Marshal(p.Pattern)
+		replacement, _ := json.Marshal(p.Replacement)
+		rules = append(rules, fmt.Sprintf("[new RegExp(%s, \"g\"), %s, %t]", pattern, replacement, p.Luhn))
+	}
+	return "var rules = [" + strings.Join(rules, ", ") + "];" +
+		` function luhn(s) { var d = s.replace(/\D/g, ""), sum = 0, alt = false;` +
+		` for (var i = d.length - 1; i >= 0; i--) { var n = d.charCodeAt(i) - 48; if (alt) { n *= 2; if (n > 9) n -= 9; } sum += n; alt = !alt; }` +
+		` return sum % 10 === 0; }` +
+		` function process(event) { var m = event.Get("message"); if (typeof m !== "string") return;` +
+		` for (var i = 0; i < rules.length; i++) { var r = rules[i]; m = m.replace(r[0], function (s) { return r[2] && !luhn(s) ? s : r[1]; }); }` +
+		` event.Put("message", m); }`
+}
+
+// MaskScript returns the script processor source of the named patterns, used by collector templates
+func MaskScript(names []string) (string, error) {
+	m, err := NewMasker(names)
+	if err != nil || m == nil {
+		return "", err
+	}
+	return m.Script(), nil
+}
+
+// luhn 校验数字的 Luhn 校验和, 忽略非数字字符
+func luhn(s string) bool {
+	var sum, digits int
+	alt := false
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			continue
+		}
+		n := int(s[i] - '0')
+		if alt {
+			n *= 2
+			if n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+		digits++
+		alt = !alt
+	}
+	return digits > 0 && sum%10 == 0
+}
+
+func init() {
+	// 13 至 19 位的卡号, 允许空格或短横线分隔, 通过 Luhn 校验才替换
+	RegisterMask("pci", MaskPattern{
+		Pattern: `\b\d(?:[ -]?\d){12,18}\b`,
+		Luhn:    true,
+	})
+	RegisterMask("email", MaskPattern{
+		Pattern: `[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`,
+	})
+	// 带分隔符的国际及北美号码, 以及中国大陆手机号
+	RegisterMask("phone", MaskPattern{
+		Pattern: `(?:\+\d{1,3}[ -]?)?(?:\(\d{3}\) ?|\b\d{3}[ .-])\d{3,4}[ .-]\d{4}\b|\b1[3-9]\d{9}\b`,
+	})
+	RegisterMask("bearer", MaskPattern{
+		Pattern:     `\b[Bb]earer\s+[A-Za-z0-9._~+/-]+=*`,
+		Replacement: "Bearer [MASKED:bearer]",
+	})
+}
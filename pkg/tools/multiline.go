@@ -0,0 +1,104 @@
+package tools
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-ucfg"
+	"github.com/elastic/go-ucfg/yaml"
+)
+
+// MultilinePreset multiline settings for a known stack trace format
+type MultilinePreset struct {
+	Pattern string `config:"pattern"`
+	Negate  bool   `config:"negate"`
+	Match   string `config:"match"`
+}
+
+var multilinePresets = make(map[string]MultilinePreset)
+
+// RegisterMultiline register multiline preset instance
+func RegisterMultiline(name string, preset MultilinePreset) {
+	multilinePresets[name] = preset
+}
+
+// GetMultiline returns the multiline preset registered by name
+func GetMultiline(name string) (MultilinePreset, error) {
+	preset, ok := multilinePresets[name]
+	if !ok {
+		var names []string
+		for n := range multilinePresets {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return preset, fmt.Errorf("unsupported multiline preset: %s, supported: %s", name, strings.Join(names, ", "))
+	}
+	return preset, nil
+}
+
+// LoadMultilinePresets registers the presets defined in a yaml file, e.g.
+//
+//	nodejs:
+//	  pattern: '^[[:space:]]+at '
+//	  negate: false
+//	  match: after
+func LoadMultilinePresets(path string) error {
+	c, err := yaml.NewConfigWithFile(path, ucfg.PathSep("."))
+	if err != nil {
+		return fmt.Errorf("read multiline presets %s failed, err: %s", path, err.Error())
+	}
+
+	// Unpack 会调用 MultilinePreset.Validate 校验每个预设
+	presets := make(map[string]MultilinePreset)
+	if err := c.Unpack(&presets); err != nil {
+		return fmt.Errorf("parse multiline presets %s failed, err: %s", path, err.Error())
+	}
+
+	for name, preset := range presets {
+		if preset.Match == "" {
+			preset.Match = "after"
+		}
+		RegisterMultiline(name, preset)
+	}
+	return nil
+}
+
+// Validate checks pattern and match of the preset, an empty match defaults to after
+func (p MultilinePreset) Validate() error {
+	if p.Pattern == "" {
+		return fmt.Errorf("pattern can not be empty")
+	}
+	if _, err := regexp.Compile(p.Pattern); err != nil {
+		return fmt.Errorf("invalid pattern %q: %s", p.Pattern, err.Error())
+	}
+	if p.Match != "" && p.Match != "after" && p.Match != "before" {
+		return fmt.Errorf("match must be after or before, got %q", p.Match)
+	}
+	return nil
+}
+
+func init() {
+	// 以下预设均匹配堆栈的后续行, 并将其追加到上一行(异常信息所在行)之后
+	RegisterMultiline("java", MultilinePreset{
+		Pattern: `^[[:space:]]+(at|\.{3})[[:space:]]+\b|^Caused by:|^[[:space:]]*Suppressed:`,
+		Negate:  false,
+		Match:   "after",
+	})
+	RegisterMultiline("python", MultilinePreset{
+		Pattern: `^[[:space:]]|^Traceback \(most recent call last\):|^During handling of the above exception|^The above exception was the direct cause|^[[:alnum:]_.]+(Error|Exception|Warning|Exit|Interrupt)\b`,
+		Negate:  false,
+		Match:   "after",
+	})
+	RegisterMultiline("go", MultilinePreset{
+		Pattern: `^[[:space:]]|^$|^goroutine [0-9]+ \[|^created by |^\[signal |^[[:alnum:]_./*()-]+\(.*\)$|^exit status [0-9]+`,
+		Negate:  false,
+		Match:   "after",
+	})
+	RegisterMultiline("dotnet", MultilinePreset{
+		Pattern: `^[[:space:]]+at |^[[:space:]]*--- End of |^[[:space:]]*---> `,
+		Negate:  false,
+		Match:   "after",
+	})
+}
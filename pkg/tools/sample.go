@@ -0,0 +1,202 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SampleRateField 采样日志的事件中记录采样比例的字段, 保留 1/N 的事件记录为 N, 全部保留的记录为 1
+const SampleRateField = "sample_rate"
+
+// DefaultSampleFields 未指定时从 JSON 日志的这些字段读取级别, 支持 . 分隔的嵌套字段
+var DefaultSampleFields = []string{"level", "severity", "log.level"}
+
+// DefaultSamplePattern 未指定时从日志内容匹配级别, 取第一个非空的分组
+const DefaultSamplePattern = `\blevel=(\w+)|\b(TRACE|DEBUG|INFO|WARN|WARNING|ERROR|FATAL)\b`
+
+// ValidateScriptRegexp 检查正则只使用 Go 及 JavaScript 共同支持的语法, 用于同时由 Go 及 Filebeat script 处理器执行的正则.
+// 不支持 (?i) 等标志及 (?P<name>) 等 (?: 以外的分组, [[:space:]] 等 POSIX 字符类, 以及 \A \z \Q \E \C \p \P
+func ValidateScriptRegexp(pattern string) error {
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			if strings.IndexByte("AzQECpP", pattern[i]) >= 0 {
+				return fmt.Errorf("regex %q uses \\%c, which the JavaScript of filebeat outputs does not support", pattern, pattern[i])
+			}
+		case inClass:
+			if c == '[' && strings.HasPrefix(pattern[i+1:], ":") {
+				return fmt.Errorf("regex %q uses a POSIX character class, which the JavaScript of filebeat outputs does not support", pattern)
+			}
+			if c == ']' {
+				inClass = false
+			}
+		case c == '[':
+			inClass = true
+			// 字符类开头的 ] 及 ^] 是普通字符
+			if strings.HasPrefix(pattern[i+1:], "^") {
+				i++
+			}
+			if strings.HasPrefix(pattern[i+1:], "]") {
+				i++
+			}
+		case c == '(' && strings.HasPrefix(pattern[i+1:], "?") && !strings.HasPrefix(pattern[i+1:], "?:"):
+			return fmt.Errorf("regex %q uses a flag or group other than (?:, which the JavaScript of filebeat outputs does not support", pattern)
+		}
+	}
+	return nil
+}
+
+// Sampler 按日志级别采样, 未设置比例的级别及无法识别级别的行全部保留
+type Sampler struct {
+	// rates 级别的采样比例, 每 N 行保留 1 行
+	rates   map[string]int
+	fields  []string
+	pattern *regexp.Regexp
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// NewSampler creates a sampler keeping 1 in rates[level] lines, fields and pattern default to
+// DefaultSampleFields and DefaultSamplePattern
+func NewSampler(rates map[string]int, fields []string, pattern string) (*Sampler, error) {
+	if len(rates) == 0 {
+		return nil, nil
+	}
+	if len(fields) == 0 {
+		fields = DefaultSampleFields
+	}
+	if pattern == "" {
+		pattern = DefaultSamplePattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid level pattern %q: %s", pattern, err.Error())
+	}
+	return &Sampler{rates: rates, fields: fields, pattern: re, counts: make(map[string]int)}, nil
+}
+
+// NormalizeLevel 统一级别的写法, 如 WARNING 及 warn 均为 warn
+func NormalizeLevel(level string) string {
+	level = strings.ToLower(strings.TrimSpace(level))
+	switch level {
+	case "warning":
+		return "warn"
+	case "err":
+		return "error"
+	}
+	return level
+}
+
+// Level 识别日志的级别, JSON 日志读取级别字段, 否则匹配正则, 无法识别时返回空
+func (s *Sampler) Level(message string) string {
+	if strings.HasPrefix(strings.TrimSpace(message), "{") {
+		var doc map[string]interface{}
+		if err := json.Unmarshal([]byte(message), &doc); err == nil {
+			for _, field := range s.fields {
+				if level, ok := lookup(doc, field).(string); ok && level != "" {
+					return NormalizeLevel(level)
+				}
+			}
+		}
+	}
+	m := s.pattern.FindStringSubmatch(message)
+	if m == nil {
+		return ""
+	}
+	if len(m) == 1 {
+		return NormalizeLevel(m[0])
+	}
+	for _, group := range m[1:] {
+		if group != "" {
+			return NormalizeLevel(group)
+		}
+	}
+	return ""
+}
+
+// Sample 返回是否保留该行及记录的采样比例
+func (s *Sampler) Sample(message string) (bool, int) {
+	level := s.Level(message)
+	rate := s.rates[level]
+	if rate <= 1 {
+		return true, 1
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.counts[level]
+	s.counts[level] = (n + 1) % rate
+	return n == 0, rate
+}
+
+// Script returns the source of the Filebeat script processor applying the same sampling to message
+func (s *Sampler) Script() string {
+	rates, _ := json.Marshal(s.rates)
+	fields, _ := json.Marshal(s.fields)
+	pattern, _ := json.Marshal(s.pattern.String())
+	return fmt.Sprintf("var rates = %s; var fields = %s; var re = new RegExp(%s); var counts = {};", rates, fields, pattern) +
+		` function normalize(l) { l = String(l).trim().toLowerCase(); return l === "warning" ? "warn" : l === "err" ? "error" : l; }` +
+		` function level(m) { if (m.trim().charAt(0) === "{") { try { var doc = JSON.parse(m);` +
+		` for (var i = 0; i < fields.length; i++) { var v = doc[fields[i]], path = fields[i].split(".");` +
+		` if (v === undefined) { v = doc; for (var j = 0; j < path.length && v !== undefined && v !== null; j++) { v = v[path[j]]; } }` +
+		` if (typeof v === "string" && v !== "") return normalize(v); } } catch (e) {} }` +
+		` var g = re.exec(m); if (g) { if (g.length === 1) return normalize(g[0]); for (var k = 1; k < g.length; k++) { if (g[k]) return normalize(g[k]); } } return ""; }` +
+		` function process(event) { var m = event.Get("message"); if (typeof m !== "string") return; var l = level(m), rate = rates[l];` +
+		` if (!rate || rate <= 1) { event.Put("` + SampleRateField + `", 1); return; }` +
+		` var n = counts[l] || 0; counts[l] = (n + 1) % rate; if (n !== 0) { event.Cancel(); return; } event.Put("` + SampleRateField + `", rate); }`
+}
+
+// SampleScript returns the script processor source of the sampling, used by collector templates
+func SampleScript(rates map[string]int, fields []string, pattern string) (string, error) {
+	s, err := NewSampler(rates, fields, pattern)
+	if err != nil || s == nil {
+		return "", err
+	}
+	return s.Script(), nil
+}
+
+// ParseSampleRates parses 10 (1 in 10 trace, debug and info lines) or info=10,debug=100
+func ParseSampleRates(value string) (map[string]int, error) {
+	rates := make(map[string]int)
+	if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		if n < 1 {
+			return nil, fmt.Errorf("sample rate %d must be at least 1", n)
+		}
+		for _, level := range []string{"trace", "debug", "info"} {
+			rates[level] = n
+		}
+		return rates, nil
+	}
+	for _, kv := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		n, err := strconv.Atoi(v)
+		if !ok || k == "" || err != nil || n < 1 {
+			return nil, fmt.Errorf("sample rate %q must be level=N, e.g. info=10", kv)
+		}
+		rates[NormalizeLevel(k)] = n
+	}
+	return rates, nil
+}
+
+// lookup 读取 . 分隔的嵌套字段, 也支持字段名本身包含 .
+func lookup(doc map[string]interface{}, field string) interface{} {
+	if v, ok := doc[field]; ok {
+		return v
+	}
+	head, rest, ok := strings.Cut(field, ".")
+	if !ok {
+		return nil
+	}
+	sub, ok := doc[head].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return lookup(sub, rest)
+}
@@ -0,0 +1,157 @@
+package tools
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// sampleCases 每行的期望结果为记录的采样比例, 丢弃的行为 0
+var sampleCases = []struct {
+	name    string
+	rates   map[string]int
+	fields  []string
+	pattern string
+	lines   []string
+	want    []int
+}{
+	{
+		name:  "default pattern",
+		rates: map[string]int{"info": 2},
+		lines: []string{"level=info a", "level=info b", "INFO c", "ERROR boom", "INFO d", "no level", "level=WARNING w"},
+		want:  []int{2, 0, 2, 1, 0, 1, 1},
+	},
+	{
+		name:  "json fields",
+		rates: map[string]int{"debug": 3, "warn": 2},
+		lines: []string{`{"level":"DEBUG"}`, `{"severity":"debug"}`, `{"log":{"level":"debug"}}`, `{"log.level":"debug"}`, `{"level":"warning"}`, `{"level":"warn"}`},
+		want:  []int{3, 0, 0, 3, 2, 0},
+	},
+	{
+		name:   "custom field",
+		rates:  map[string]int{"info": 2},
+		fields: []string{"lvl"},
+		// 字段不存在时按正则匹配内容
+		lines: []string{`{"lvl":"info"}`, `{"level":"error","lvl":"info"}`, `{"msg":"level=info"}`, `{"lvl":1,"msg":"INFO"}`, `{broken INFO`},
+		want:  []int{2, 0, 2, 0, 2},
+	},
+	{
+		name:    "custom pattern",
+		rates:   map[string]int{"warn": 2, "debug": 10},
+		pattern: `^\[(\w+)\]`,
+		lines:   []string{"[WARNING] a", "[warn] b", "[error] c", "warn d", "[debug] e", "[Debug] f"},
+		want:    []int{2, 0, 1, 1, 10, 0},
+	},
+	{
+		name:    "pattern without groups",
+		rates:   map[string]int{"err": 2, "error": 2},
+		pattern: `ERR|error`,
+		lines:   []string{"ERR a", "error b", "x ERR c"},
+		want:    []int{2, 0, 2},
+	},
+}
+
+func TestSample(t *testing.T) {
+	for _, c := range sampleCases {
+		s, err := NewSampler(c.rates, c.fields, c.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		for _, line := range c.lines {
+			keep, rate := s.Sample(line)
+			if !keep {
+				rate = 0
+			}
+			got = append(got, rate)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: Sample = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// scriptHarness 模拟 Filebeat script 处理器的 event, 依次处理每行并输出事件的字段, 丢弃的事件为 null.
+// 变量名不能与脚本中的全局变量重复
+const scriptHarness = `
+var results = [];
+for (var i = 0; i < lines.length; i++) {
+	var data = { message: lines[i] }, cancelled = false;
+	process({
+		Get: function (k) { return data[k]; },
+		Put: function (k, v) { data[k] = v; },
+		Cancel: function () { cancelled = true; },
+	});
+	results.push(cancelled ? null : data);
+}
+console.log(JSON.stringify(results));
+`
+
+// runScript 使用 node 执行 Filebeat script 处理器的脚本, 返回每行处理后的事件字段, 没有安装 node 时跳过
+func runScript(t *testing.T, source string, lines []string) []map[string]interface{} {
+	t.Helper()
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+	data, _ := json.Marshal(lines)
+	path := filepath.Join(t.TempDir(), "script.js")
+	if err := ioutil.WriteFile(path, []byte(source+"\nvar lines = "+string(data)+";"+scriptHarness), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(node, path).CombinedOutput()
+	if err != nil {
+		t.Fatalf("node failed: %v\n%s", err, out)
+	}
+	var events []map[string]interface{}
+	if err := json.Unmarshal(out, &events); err != nil {
+		t.Fatalf("invalid output %s", out)
+	}
+	return events
+}
+
+// TestSampleScript Filebeat 类型的输出执行的 JavaScript 与原生输出的采样结果一致
+func TestSampleScript(t *testing.T) {
+	for _, c := range sampleCases {
+		source, err := SampleScript(c.rates, c.fields, c.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		for _, event := range runScript(t, source, c.lines) {
+			rate, _ := event[SampleRateField].(float64)
+			got = append(got, int(rate))
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: Script = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestValidateScriptRegexp(t *testing.T) {
+	cases := []struct {
+		pattern string
+		ok      bool
+	}{
+		{DefaultSamplePattern, true},
+		{`^\[(\w+)\]`, true},
+		{`(?:a|b)+\d{2}`, true},
+		{`[]a]|[^]b]`, true},
+		{`\[:x:\]`, true},
+		{`(?i)error`, false},
+		{`(?P<level>\w+)`, false},
+		{`(?s).`, false},
+		{`[[:space:]]+`, false},
+		{`\Aerror\z`, false},
+		{`\p{Han}`, false},
+		{`\Q.*\E`, false},
+	}
+	for _, c := range cases {
+		if err := ValidateScriptRegexp(c.pattern); (err == nil) != c.ok {
+			t.Errorf("ValidateScriptRegexp(%q) = %v, want ok %v", c.pattern, err, c.ok)
+		}
+	}
+}
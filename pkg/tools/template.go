@@ -0,0 +1,18 @@
+package tools
+
+import (
+	"strings"
+	"text/template"
+)
+
+// TemplateFuncs functions available in collector templates
+var TemplateFuncs = template.FuncMap{
+	"quote":        Quote,
+	"maskScript":   MaskScript,
+	"sampleScript": SampleScript,
+}
+
+// Quote returns value as a single-quoted YAML scalar
+func Quote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
@@ -2,6 +2,8 @@ package tools
 
 import (
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -14,3 +16,43 @@ func ReadFile(path string, separator string) ([]string, error) {
 
 	return strings.Split(string(data), separator), nil
 }
+
+// WriteFileAtomic 先写入同目录下的临时文件再重命名, 读取方不会看到写了一半的文件
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	// 临时文件以 .tmp 结尾, 不会匹配采集器的 *.yml
+	tmp, err := ioutil.TempFile(dir, "."+base+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// 同步目录, 保证重命名落盘
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}